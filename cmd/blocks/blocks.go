@@ -8,62 +8,89 @@ import (
 	"os"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
-	"github.com/redis/go-redis/v9"
 	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/mod"
+	"github.com/shruggr/casemod-indexer/postgres"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/txostore"
+	"github.com/shruggr/casemod-indexer/webhook"
 )
 
-var rdb *redis.Client
+var rdb storage.Storage
 
-var cache *redis.Client
+var cache storage.Storage
 
 var VERBOSE int = 0
 var PAGE_SIZE = uint(10000)
+var MEMPOOL_WINDOW time.Duration
 
 const REFRESH = 30 * time.Second
 
 var ctx = context.Background()
 
+var store = &txostore.Store{
+	Indexers: mod.Indexers(),
+}
+
 func init() {
 	wd, _ := os.Getwd()
 	log.Println("CWD:", wd)
 	godotenv.Load(fmt.Sprintf(`%s/../../.env`, wd))
 	flag.IntVar(&VERBOSE, "v", 0, "Verbose")
+	flag.DurationVar(&MEMPOOL_WINDOW, "m", txostore.DEFAULT_MEMPOOL_WINDOW, "Evict mempool txns unmined after")
 	flag.Parse()
 
-	if opt, err := redis.ParseURL(os.Getenv("REDISDB")); err != nil {
+	var err error
+	if rdb, err = storage.Open(os.Getenv("REDISDB")); err != nil {
 		panic(err)
-	} else {
-		rdb = redis.NewClient(opt)
 	}
 
-	if opt, err := redis.ParseURL(os.Getenv("REDISCACHE")); err != nil {
+	if cache, err = storage.Open(os.Getenv("REDISCACHE")); err != nil {
 		panic(err)
-	} else {
-		cache = redis.NewClient(opt)
 	}
 
 	db.Initialize(rdb, cache, 10)
+	if POSTGRES := os.Getenv("POSTGRES_FULL"); POSTGRES != "" {
+		if pool, err := pgxpool.New(ctx, POSTGRES); err != nil {
+			panic(err)
+		} else {
+			store.Sinks = append(store.Sinks, postgres.NewSink(pool))
+		}
+	}
+	// Queued deliveries cannot be reverted, so webhooks go out last
+	store.Sinks = append(store.Sinks, webhook.NewSink(rdb))
 }
 
 func main() {
 	if err := syncBlocks(); err != nil {
 		log.Panicln(err)
 	}
+	if report, err := store.SyncMempool(ctx, MEMPOOL_WINDOW); err != nil {
+		log.Panicln(err)
+	} else {
+		log.Println("Mempool promoted", len(report.Promoted), "evicted", len(report.Evicted))
+	}
 }
 
 func syncBlocks() (err error) {
 	fromHeight := uint32(1)
-	if blockIds, err := db.Txos.ZRangeArgsWithScores(ctx, redis.ZRangeArgs{
-		Key:     db.BlockIdKey,
-		ByScore: true,
-		Rev:     true,
-		Count:   1,
-		Stop:    50000000,
-	}).Result(); err != nil {
+	var orphans []string
+	if tip, err := store.Tip(ctx); err != nil {
 		log.Panicln(err)
-	} else if len(blockIds) > 0 {
-		fromHeight = uint32(blockIds[0].Score) - 5
+	} else if tip > 0 {
+		if fork, err := store.FindFork(ctx, tip); err != nil {
+			return err
+		} else if fork > 0 {
+			log.Println("Reorg detected at", fork)
+			if orphans, err = store.Reorg(ctx, fork); err != nil {
+				return err
+			}
+			fromHeight = fork
+		} else {
+			fromHeight = tip - 5
+		}
 	}
 
 	for {
@@ -76,5 +103,8 @@ func syncBlocks() (err error) {
 		}
 		fromHeight = height
 	}
+	if len(orphans) > 0 {
+		return store.Reingest(ctx, orphans)
+	}
 	return nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -13,23 +14,25 @@ import (
 	"time"
 
 	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
-	"github.com/redis/go-redis/v9"
 	"github.com/shruggr/casemod-indexer/db"
 	"github.com/shruggr/casemod-indexer/listener"
+	"github.com/shruggr/casemod-indexer/mod"
 	"github.com/shruggr/casemod-indexer/mod/bsv21"
-	"github.com/shruggr/casemod-indexer/mod/ord"
+	"github.com/shruggr/casemod-indexer/postgres"
+	"github.com/shruggr/casemod-indexer/storage"
 	"github.com/shruggr/casemod-indexer/txostore"
-	"github.com/shruggr/casemod-indexer/types"
+	"github.com/shruggr/casemod-indexer/webhook"
 )
 
 const CONCURRENCY = 8
 
 var PAGE_SIZE = int64(250)
 
-var rdb *redis.Client
+var rdb storage.Storage
 
-var cache *redis.Client
+var cache storage.Storage
 
 var INDEXER string = "bsv21"
 var TOPIC string
@@ -49,36 +52,39 @@ func init() {
 	flag.IntVar(&VERBOSE, "v", 0, "Verbose")
 	flag.Parse()
 
-	if opt, err := redis.ParseURL(os.Getenv("REDISDB")); err != nil {
+	var err error
+	if rdb, err = storage.Open(os.Getenv("REDISDB")); err != nil {
 		panic(err)
-	} else {
-		rdb = redis.NewClient(opt)
 	}
 
-	if opt, err := redis.ParseURL(os.Getenv("REDISCACHE")); err != nil {
+	if cache, err = storage.Open(os.Getenv("REDISCACHE")); err != nil {
 		panic(err)
-	} else {
-		cache = redis.NewClient(opt)
 	}
 
 	db.Initialize(rdb, cache, 8)
+	if POSTGRES := os.Getenv("POSTGRES_FULL"); POSTGRES != "" {
+		if pool, err := pgxpool.New(ctx, POSTGRES); err != nil {
+			panic(err)
+		} else {
+			store.Sinks = append(store.Sinks, postgres.NewSink(pool))
+		}
+	}
+	// Queued deliveries cannot be reverted, so webhooks go out last
+	store.Sinks = append(store.Sinks, webhook.NewSink(rdb))
 }
 
 var prevProgress string
 var prevScore atomic.Value
 var store = &txostore.Store{
-	Indexers: []types.Indexer{
-		&bsv21.Bsv21Indexer{
-			InscrptionIndexer: &ord.InscriptionIndexer{},
-		},
-	},
+	Indexers: mod.Indexers(),
 }
 
 func main() {
-	progress, err := rdb.HGet(ctx, db.ProgressKey, INDEXER).Result()
-	if err != nil && err != redis.Nil {
+	fields, err := rdb.LoadFields(ctx, db.ProgressKey, INDEXER)
+	if err != nil {
 		panic(err)
 	}
+	progress := string(fields[INDEXER])
 	if progress == "" {
 		progress = "-"
 		prevScore.Store(0.0)
@@ -103,17 +109,21 @@ func processLogs(progress string) {
 	var wg sync.WaitGroup
 	for {
 		log.Println("Progress", progress)
-		if stream, err := rdb.XRangeN(ctx, db.LogKey(INDEXER), progress, "+", PAGE_SIZE).Result(); err != nil {
+		if stream, err := rdb.ReadStream(ctx, db.LogKey(INDEXER), &storage.StreamRange{
+			Start: progress,
+			Stop:  "+",
+			Count: PAGE_SIZE,
+		}); err != nil {
 			panic(err)
 		} else {
 			start := time.Now()
 			for _, msg := range stream {
 				wg.Add(1)
 				limiter <- struct{}{}
-				progress = msg.ID
-				go func(msg redis.XMessage) {
-					score := logIdToScore(msg.ID)
-					txid := msg.Values["txn"].(string)
+				progress = msg.Id
+				go func(msg *storage.StreamEntry) {
+					score := logIdToScore(msg.Id)
+					txid := msg.Values["txn"]
 					if VERBOSE > 0 {
 						log.Println("Parsing", txid)
 					}
@@ -131,10 +141,10 @@ func processLogs(progress string) {
 								continue
 							} else if bsv21, ok := item.Obj.(*bsv21.Bsv21); ok {
 								queueKey := db.QueueKey(INDEXER) + bsv21.Id.String()
-								if err = db.Txos.ZAdd(ctx, queueKey, redis.Z{
-									Score:  score,
-									Member: txid,
-								}).Err(); err != nil {
+								if err = db.Txos.Write(ctx, func(w storage.Writer) error {
+									w.AddMember(queueKey, txid, score)
+									return nil
+								}); err != nil {
 									panic(err)
 								}
 								ids[queueKey] = struct{}{}
@@ -148,7 +158,10 @@ func processLogs(progress string) {
 			wg.Wait()
 			elapsed := time.Since(start)
 			log.Println("Processed", len(stream), "in", elapsed, "(", float64(len(stream))/elapsed.Seconds(), "tx/s )")
-			if err = rdb.HSet(ctx, db.ProgressKey, INDEXER, progress).Err(); err != nil {
+			if err = rdb.Write(ctx, func(w storage.Writer) error {
+				w.SaveFields(db.ProgressKey, map[string][]byte{INDEXER: []byte(progress)})
+				return nil
+			}); err != nil {
 				panic(err)
 			}
 
@@ -168,13 +181,14 @@ func processQueue() {
 	var wg sync.WaitGroup
 	for {
 		queueKey := db.QueueKey(INDEXER)
-		iter := db.Txos.Scan(ctx, 0, queueKey+"*", 1000).Iterator()
-		count := 0
-		for iter.Next(ctx) {
-			count++
+		keys, err := db.Txos.Keys(ctx, queueKey)
+		if err != nil {
+			panic(err)
+		}
+		for _, key := range keys {
 			wg.Add(1)
 			limiter <- struct{}{}
-			tokenId := strings.TrimPrefix(iter.Val(), queueKey)
+			tokenId := strings.TrimPrefix(key, queueKey)
 			go func(tokenId string) {
 				defer func() {
 					<-limiter
@@ -189,7 +203,7 @@ func processQueue() {
 			}(tokenId)
 		}
 		wg.Wait()
-		if count == 0 {
+		if len(keys) == 0 {
 			time.Sleep(60 * time.Second)
 		}
 	}
@@ -197,29 +211,34 @@ func processQueue() {
 
 func processToken(tokenId string) {
 	queueKey := db.QueueKey(INDEXER) + tokenId
-	if txids, err := db.Txos.ZRangeArgs(ctx, redis.ZRangeArgs{
-		Key:     queueKey,
-		Start:   0,
-		Stop:    prevScore.Load().(float64),
-		ByScore: true,
-		Count:   100,
-	}).Result(); err != nil {
+	if members, err := db.Txos.RangeByScore(ctx, queueKey, &storage.ScoreRange{
+		Min:   0,
+		Max:   prevScore.Load().(float64),
+		Count: 100,
+	}); err != nil {
 		panic(err)
 	} else {
-		pipe := db.Txos.Pipeline()
-		for _, txid := range txids {
+		txids := make([]string, 0, len(members))
+		for _, m := range members {
+			txid := m.Member
 			if VERBOSE > 0 {
 				log.Println("Processing", tokenId, txid)
 			}
-			if tx, err := db.LoadTxAndProof(ctx, txid); err != nil {
+			if tx, err := db.LoadTxAndProof(ctx, txid); errors.Is(err, db.ErrInvalidProof) {
+				log.Println("Skipping", txid, err)
+			} else if err != nil {
 				panic(err)
 			} else if _, err := store.Ingest(ctx, tx); err != nil {
 				panic(err)
-			} else if err := pipe.ZRem(ctx, queueKey, txid).Err(); err != nil {
-				panic(err)
 			}
+			txids = append(txids, txid)
 		}
-		if _, err := pipe.Exec(ctx); err != nil {
+		if err := db.Txos.Write(ctx, func(w storage.Writer) error {
+			for _, txid := range txids {
+				w.RemoveMember(queueKey, txid)
+			}
+			return nil
+		}); err != nil {
 			panic(err)
 		}
 	}
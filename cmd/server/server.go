@@ -1,44 +1,68 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/GorillaPool/go-junglebus"
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/bitcoin-sv/go-sdk/transaction/broadcaster"
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/swagger"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
-	"github.com/redis/go-redis/v9"
 	_ "github.com/shruggr/casemod-indexer/cmd/server/docs"
 	"github.com/shruggr/casemod-indexer/db"
-	"github.com/shruggr/casemod-indexer/mod/bsv21"
+	"github.com/shruggr/casemod-indexer/mod"
 	"github.com/shruggr/casemod-indexer/mod/ord"
+	"github.com/shruggr/casemod-indexer/mod/p2pkh"
+	"github.com/shruggr/casemod-indexer/storage"
 	"github.com/shruggr/casemod-indexer/txostore"
 	"github.com/shruggr/casemod-indexer/types"
+	"github.com/shruggr/casemod-indexer/webhook"
 )
 
 var POSTGRES string
 var CONCURRENCY int
 var PORT int
-var rdb *redis.Client
-var cache *redis.Client
+var rdb storage.Storage
+var cache storage.Storage
 var jb *junglebus.Client
+var arc *broadcaster.Arc
 
 const INCLUDE_THREASHOLD = 10000000
 const HOLDER_CACHE_TIME = 24 * time.Hour
+const SUBSCRIBE_KEEPALIVE = 15 * time.Second
+const MAX_OUTPOINTS = 1000
+const CONTENT_CACHE_IMMUTABLE = "public, max-age=31536000, immutable"
+const CONTENT_CACHE_MINED = "public, max-age=3600"
+const CONTENT_CACHE_MEMPOOL = "public, max-age=60"
+
+// CONTENT_IMMUTABLE_DEPTH is how many blocks must be mined on top of content
+// before it is cached as immutable, past the reach of a reorg.
+const CONTENT_IMMUTABLE_DEPTH = db.REORG_PAGE_SIZE
+
+type outpointsRequest struct {
+	Outpoints []string                `json:"outpoints"`
+	Fields    *txostore.LoadTxoParams `json:"fields"`
+}
 
 var store = &txostore.Store{
-	Indexers: []types.Indexer{
-		&bsv21.Bsv21Indexer{
-			InscrptionIndexer: &ord.InscriptionIndexer{},
-		},
-	},
+	Indexers: mod.Indexers(),
 }
 
 func init() {
@@ -58,16 +82,12 @@ func init() {
 	}
 	config.MaxConnIdleTime = 15 * time.Second
 
-	if opt, err := redis.ParseURL(os.Getenv("REDISDB")); err != nil {
+	if rdb, err = storage.Open(os.Getenv("REDISDB")); err != nil {
 		panic(err)
-	} else {
-		rdb = redis.NewClient(opt)
 	}
 
-	if opt, err := redis.ParseURL(os.Getenv("REDISCACHE")); err != nil {
+	if cache, err = storage.Open(os.Getenv("REDISCACHE")); err != nil {
 		panic(err)
-	} else {
-		cache = redis.NewClient(opt)
 	}
 
 	JUNGLEBUS := os.Getenv("JUNGLEBUS")
@@ -82,7 +102,17 @@ func init() {
 		log.Panicln(err.Error())
 	}
 
+	ARC := os.Getenv("ARC")
+	if ARC == "" {
+		ARC = "https://arc.gorillapool.io"
+	}
+	arc = &broadcaster.Arc{
+		ApiUrl: ARC + "/v1",
+		ApiKey: os.Getenv("TAAL_TOKEN"),
+	}
+
 	db.Initialize(rdb, cache, 8)
+	store.Sinks = append(store.Sinks, webhook.NewSink(rdb))
 }
 
 // @title BSV21 API
@@ -118,6 +148,114 @@ func main() {
 		}
 	})
 
+	app.Get("/v1/origins/:origin/latest", func(c *fiber.Ctx) error {
+		if origin, err := types.NewOutpointFromString(c.Params("origin")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if hop, err := store.OriginLatest(c.Context(), origin); err != nil {
+			return err
+		} else if hop == nil {
+			return &fiber.Error{
+				Code:    fiber.StatusNotFound,
+				Message: "Not Found",
+			}
+		} else {
+			return c.JSON(hop)
+		}
+	})
+
+	app.Get("/v1/origins/:origin/history", func(c *fiber.Ctx) error {
+		if origin, err := types.NewOutpointFromString(c.Params("origin")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if hops, err := store.OriginHistory(c.Context(), origin); err != nil {
+			return err
+		} else {
+			return c.JSON(hops)
+		}
+	})
+
+	app.Get("/v1/sats/outpoint/:outpoint", func(c *fiber.Ctx) error {
+		if outpoint, err := types.NewOutpointFromString(c.Params("outpoint")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if txo, err := store.LoadTxo(c.Context(), outpoint, &txostore.LoadTxoParams{
+			Obj:  true,
+			Tags: []string{ord.SAT_TAG},
+		}); err != nil {
+			return err
+		} else if txo == nil || txo.Data[ord.SAT_TAG] == nil {
+			return &fiber.Error{
+				Code:    fiber.StatusNotFound,
+				Message: "Not Found",
+			}
+		} else {
+			return c.JSON(txo.Data[ord.SAT_TAG].Obj)
+		}
+	})
+
+	app.Get("/v1/sats/:sat/origins", func(c *fiber.Ctx) error {
+		if sat, err := strconv.ParseUint(c.Params("sat"), 10, 64); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if origins, err := store.SatOrigins(c.Context(), sat); err != nil {
+			return err
+		} else {
+			return c.JSON(origins)
+		}
+	})
+
+	app.Get("/v1/content/origin/:origin", func(c *fiber.Ctx) error {
+		if origin, err := types.NewOutpointFromString(c.Params("origin")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if txo, insc, err := latestInscription(c.Context(), origin); err != nil {
+			return err
+		} else if insc == nil {
+			return &fiber.Error{
+				Code:    fiber.StatusNotFound,
+				Message: "Not Found",
+			}
+		} else {
+			c.Set(fiber.HeaderContentLocation, "/v1/content/"+txo.Outpoint.String())
+			return sendContent(c, insc, CONTENT_CACHE_MEMPOOL)
+		}
+	})
+
+	app.Get("/v1/content/:outpoint", func(c *fiber.Ctx) error {
+		if outpoint, err := types.NewOutpointFromString(c.Params("outpoint")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if txo, insc, err := loadInscription(c.Context(), outpoint); err != nil {
+			return err
+		} else if insc == nil {
+			return &fiber.Error{
+				Code:    fiber.StatusNotFound,
+				Message: "Not Found",
+			}
+		} else if txo.Block == nil {
+			return sendContent(c, insc, CONTENT_CACHE_MEMPOOL)
+		} else if tip, err := store.Tip(c.Context()); err != nil {
+			return err
+		} else if tip >= txo.Block.Height+CONTENT_IMMUTABLE_DEPTH {
+			return sendContent(c, insc, CONTENT_CACHE_IMMUTABLE)
+		} else {
+			return sendContent(c, insc, CONTENT_CACHE_MINED)
+		}
+	})
+
 	app.Get("/v1/txos/outpoint/:outpoint", func(c *fiber.Ctx) error {
 		if outpoint, err := types.NewOutpointFromString(c.Params("outpoint")); err != nil {
 			return &fiber.Error{
@@ -155,6 +293,50 @@ func main() {
 		}
 	})
 
+	app.Post("/v1/txos/outpoints", func(c *fiber.Ctx) error {
+		req := &outpointsRequest{}
+		if err := c.BodyParser(req); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if len(req.Outpoints) > MAX_OUTPOINTS {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: fmt.Sprintf("at most %d outpoints", MAX_OUTPOINTS),
+			}
+		}
+		outpoints := make([]*types.Outpoint, 0, len(req.Outpoints))
+		for _, op := range req.Outpoints {
+			if outpoint, err := types.NewOutpointFromString(op); err != nil {
+				return &fiber.Error{
+					Code:    fiber.StatusBadRequest,
+					Message: err.Error(),
+				}
+			} else {
+				outpoints = append(outpoints, outpoint)
+			}
+		}
+		if txos, err := store.LoadTxos(c.Context(), outpoints, req.Fields); err != nil {
+			return err
+		} else {
+			return c.JSON(txos)
+		}
+	})
+
+	app.Get("/v1/txos/outpoint/:outpoint/conflicts", func(c *fiber.Ctx) error {
+		if outpoint, err := types.NewOutpointFromString(c.Params("outpoint")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if conflict, err := store.Conflicts(c.Context(), outpoint); err != nil {
+			return err
+		} else {
+			return c.JSON(conflict)
+		}
+	})
+
 	app.Get("/v1/txos/txid/:txid", func(c *fiber.Ctx) error {
 		if txos, err := store.LoadTxosByTxid(c.Context(), c.Params("txid"), nil); err != nil {
 			return err
@@ -170,13 +352,287 @@ func main() {
 				Code:    fiber.StatusBadRequest,
 				Message: err.Error(),
 			}
-		} else if txos, err := store.LoadTxosByTxid(c.Context(), c.Params("txid"), nil); err != nil {
+		} else if txos, err := store.LoadTxosByTxid(c.Context(), c.Params("txid"), params); err != nil {
+			return err
+		} else {
+			return c.JSON(txos)
+		}
+	})
+
+	app.Post("/v1/tx", func(c *fiber.Ctx) error {
+		rawtx := c.Body()
+		if decoded, err := hex.DecodeString(string(rawtx)); err == nil {
+			rawtx = decoded
+		}
+		var failure *transaction.BroadcastFailure
+		if tx, err := txostore.ParseTx(rawtx); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if idxCtx, err := store.Broadcast(c.Context(), tx, arc); errors.Is(err, txostore.ErrInvalidBEEF) {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if errors.As(err, &failure) {
+			return &fiber.Error{
+				Code:    fiber.StatusUnprocessableEntity,
+				Message: fmt.Sprintf("%s: %s", failure.Code, failure.Description),
+			}
+		} else if err != nil {
+			return err
+		} else {
+			return c.JSON(idxCtx.Txos)
+		}
+	})
+
+	app.Post("/v1/tx/beef", func(c *fiber.Ctx) error {
+		beef := c.Body()
+		if decoded, err := hex.DecodeString(string(beef)); err == nil {
+			beef = decoded
+		}
+		if txos, err := store.IngestBEEF(c.Context(), beef); errors.Is(err, txostore.ErrInvalidBEEF) {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if err != nil {
 			return err
 		} else {
 			return c.JSON(txos)
 		}
 	})
 
+	app.Get("/v1/owner/:address/balance", func(c *fiber.Ctx) error {
+		if _, ok := store.IndexerMap()[p2pkh.TAG]; !ok {
+			return &fiber.Error{
+				Code:    fiber.StatusNotFound,
+				Message: "p2pkh is not indexed",
+			}
+		} else if _, err := types.NewPKHashFromAddress(c.Params("address")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if balance, err := store.Balance(c.Context(), p2pkh.TAG, p2pkh.AddressEvent(c.Params("address"))); err != nil {
+			return err
+		} else {
+			return c.JSON(balance)
+		}
+	})
+
+	app.Get("/v1/owner/:address/utxos", func(c *fiber.Ctx) error {
+		if _, ok := store.IndexerMap()[p2pkh.TAG]; !ok {
+			return &fiber.Error{
+				Code:    fiber.StatusNotFound,
+				Message: "p2pkh is not indexed",
+			}
+		} else if _, err := types.NewPKHashFromAddress(c.Params("address")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if page, err := store.Utxos(c.Context(), p2pkh.TAG, p2pkh.AddressEvent(c.Params("address")), &txostore.OwnerTxoParams{
+			Cursor: c.Query("cursor"),
+			Limit:  int64(min(c.QueryInt("limit", txostore.DEFAULT_PAGE_SIZE), txostore.MAX_PAGE_SIZE)),
+			Fields: &txostore.LoadTxoParams{
+				Block: true,
+			},
+		}); errors.Is(err, txostore.ErrInvalidCursor) {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if err != nil {
+			return err
+		} else {
+			return c.JSON(page)
+		}
+	})
+
+	app.Get("/v1/owner/:address/txos", func(c *fiber.Ctx) error {
+		if owner, err := types.NewPKHashFromAddress(c.Params("address")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if page, err := store.OwnerTxos(c.Context(), owner, ownerTxoParams(c)); errors.Is(err, txostore.ErrInvalidCursor) || errors.Is(err, txostore.ErrInvalidStatus) {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if err != nil {
+			return err
+		} else {
+			return c.JSON(page)
+		}
+	})
+
+	app.Get("/v1/owner/:address/history", func(c *fiber.Ctx) error {
+		if owner, err := types.NewPKHashFromAddress(c.Params("address")); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if page, err := store.OwnerHistory(c.Context(), owner, ownerTxoParams(c)); errors.Is(err, txostore.ErrInvalidCursor) {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if err != nil {
+			return err
+		} else {
+			return c.JSON(page)
+		}
+	})
+
+	app.Get("/v1/subscribe", func(c *fiber.Ctx) error {
+		channels, err := subscribeChannels(c)
+		if err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		messages, err := rdb.Subscribe(ctx, channels...)
+		if err != nil {
+			cancel()
+			return err
+		}
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer cancel()
+			ticker := time.NewTicker(SUBSCRIBE_KEEPALIVE)
+			defer ticker.Stop()
+			for {
+				select {
+				case msg, ok := <-messages:
+					if !ok {
+						return
+					}
+					if txo := loadPublished(ctx, msg); txo != nil {
+						if data, err := json.Marshal(txo); err != nil {
+							log.Println("Subscribe", err)
+						} else {
+							fmt.Fprintf(w, "event: %s\ndata: %s\n\n", msg.Channel, data)
+						}
+					}
+				case <-ticker.C:
+					fmt.Fprint(w, ": keepalive\n\n")
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+
+	app.Use("/v1/subscribe/ws", func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		} else if channels, err := subscribeChannels(c); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else {
+			c.Locals("channels", channels)
+			return c.Next()
+		}
+	})
+
+	app.Get("/v1/subscribe/ws", websocket.New(func(conn *websocket.Conn) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		messages, err := rdb.Subscribe(ctx, conn.Locals("channels").([]string)...)
+		if err != nil {
+			log.Println("Subscribe", err)
+			return
+		}
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		for msg := range messages {
+			if txo := loadPublished(ctx, msg); txo != nil {
+				if err := conn.WriteJSON(txo); err != nil {
+					return
+				}
+			}
+		}
+	}))
+
+	app.Post("/v1/webhooks", func(c *fiber.Ctx) error {
+		hook := &webhook.Webhook{}
+		if err := c.BodyParser(hook); err != nil {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if err := webhook.Register(c.Context(), rdb, hook); errors.Is(err, webhook.ErrInvalidWebhook) {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if err != nil {
+			return err
+		} else {
+			return c.Status(fiber.StatusCreated).JSON(hook)
+		}
+	})
+
+	app.Get("/v1/webhooks/:id", func(c *fiber.Ctx) error {
+		if hook, err := webhook.Load(c.Context(), rdb, c.Params("id")); err != nil {
+			return err
+		} else if hook == nil {
+			return &fiber.Error{
+				Code:    fiber.StatusNotFound,
+				Message: "Not Found",
+			}
+		} else {
+			hook.Secret = ""
+			return c.JSON(hook)
+		}
+	})
+
+	app.Delete("/v1/webhooks/:id", func(c *fiber.Ctx) error {
+		if err := webhook.Delete(c.Context(), rdb, c.Params("id")); err != nil {
+			return err
+		} else {
+			return c.SendStatus(fiber.StatusNoContent)
+		}
+	})
+
+	app.Get("/v1/inscriptions/search", func(c *fiber.Ctx) error {
+		words := ord.Words(c.Query("q"))
+		if len(words) == 0 {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: "no words to search for",
+			}
+		}
+		if results, err := store.RankTxos(c.Context(), "insc", "word", words, min(c.QueryInt("limit", txostore.DEFAULT_PAGE_SIZE), txostore.MAX_PAGE_SIZE), &txostore.LoadTxoParams{
+			Block:     true,
+			Spend:     true,
+			Obj:       true,
+			Tags:      store.Tags(),
+			NoContent: true,
+		}); err != nil {
+			return err
+		} else {
+			return c.JSON(results)
+		}
+	})
+
 	app.Post("/v1/txos/search", func(c *fiber.Ctx) error {
 		var search txostore.SearchTxoParams
 		if err := c.BodyParser(&search); err != nil {
@@ -185,7 +641,12 @@ func main() {
 				Message: err.Error(),
 			}
 		}
-		if txos, err := store.SearchTxos(c.Context(), search); err != nil {
+		if txos, err := store.SearchTxos(c.Context(), search); errors.Is(err, txostore.ErrInvalidQuery) || errors.Is(err, txostore.ErrInvalidStatus) {
+			return &fiber.Error{
+				Code:    fiber.StatusBadRequest,
+				Message: err.Error(),
+			}
+		} else if err != nil {
 			return err
 		} else {
 			return c.JSON(txos)
@@ -280,6 +741,145 @@ func main() {
 	app.Listen(fmt.Sprintf(":%s", PORT))
 }
 
+// subscribeChannels reads the event keys a subscriber wants from the comma
+// separated events (tag:id:value) and owners (address) query parameters.
+func subscribeChannels(c *fiber.Ctx) ([]string, error) {
+	channels := make([]string, 0)
+	if events := c.Query("events"); events != "" {
+		for _, event := range strings.Split(events, ",") {
+			parts := strings.SplitN(event, ":", 3)
+			if len(parts) < 3 {
+				return nil, fmt.Errorf("invalid event %s", event)
+			}
+			channels = append(channels, db.EventKey(parts[0], &types.EventLog{
+				Label: parts[1],
+				Value: parts[2],
+			}))
+		}
+	}
+	if owners := c.Query("owners"); owners != "" {
+		for _, address := range strings.Split(owners, ",") {
+			if owner, err := types.NewPKHashFromAddress(address); err != nil {
+				return nil, err
+			} else {
+				channels = append(channels, db.OwnerKey(owner))
+			}
+		}
+	}
+	if len(channels) == 0 {
+		return nil, errors.New("no events or owners to subscribe to")
+	}
+	return channels, nil
+}
+
+// loadPublished loads the txo named by a published message.
+func loadPublished(ctx context.Context, msg *storage.Message) *types.Txo {
+	if outpoint, err := types.NewOutpointFromString(msg.Payload); err != nil {
+		log.Println("Subscribe", err)
+	} else if txo, err := store.LoadTxo(ctx, outpoint, nil); err != nil {
+		log.Println("Subscribe", err)
+	} else {
+		return txo
+	}
+	return nil
+}
+
+// loadInscription loads the txo at outpoint along with its inscription, which
+// is nil if it has none.
+func loadInscription(ctx context.Context, outpoint *types.Outpoint) (*types.Txo, *ord.Inscription, error) {
+	txo, err := store.LoadTxo(ctx, outpoint, &txostore.LoadTxoParams{
+		Block: true,
+		Obj:   true,
+		Tags:  []string{"insc"},
+	})
+	if err != nil || txo == nil {
+		return nil, nil, err
+	} else if data := txo.Data["insc"]; data == nil {
+		return txo, nil, nil
+	} else if insc, ok := data.Obj.(*ord.Inscription); ok && insc.File != nil {
+		return txo, insc, nil
+	}
+	return txo, nil, nil
+}
+
+// latestInscription finds the most recent inscription on the outputs carrying
+// origin.
+func latestInscription(ctx context.Context, origin *types.Outpoint) (*types.Txo, *ord.Inscription, error) {
+	members, err := rdb.RangeByScore(ctx, db.EventKey(ord.ORIGIN_TAG, &types.EventLog{
+		Label: ord.ORIGIN_TAG,
+		Value: origin.String(),
+	}), storage.AllScores())
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return math.Abs(members[i].Score) > math.Abs(members[j].Score)
+	})
+	for _, m := range members {
+		if outpoint, err := types.NewOutpointFromString(m.Member); err != nil {
+			return nil, nil, err
+		} else if txo, insc, err := loadInscription(ctx, outpoint); err != nil {
+			return nil, nil, err
+		} else if insc != nil {
+			return txo, insc, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+// sendContent serves the inscribed file, honoring If-None-Match against its
+// hash and a single byte range.
+func sendContent(c *fiber.Ctx, insc *ord.Inscription, cacheControl string) error {
+	file := insc.File
+	contentType := file.Type
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
+	}
+	etag := fmt.Sprintf(`"%x"`, []byte(file.Hash))
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, cacheControl)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	if match := c.Get(fiber.HeaderIfNoneMatch); match != "" && (match == etag || match == "*") {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	if c.Get(fiber.HeaderRange) == "" {
+		return c.Send(file.Content)
+	}
+	if r, err := c.Range(len(file.Content)); err == fiber.ErrRangeUnsatisfiable {
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", len(file.Content)))
+		return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+	} else if err != nil || r.Type != "bytes" || len(r.Ranges) != 1 {
+		return c.Send(file.Content)
+	} else {
+		start, end := r.Ranges[0].Start, r.Ranges[0].End
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, len(file.Content)))
+		c.Status(fiber.StatusPartialContent)
+		return c.Send(file.Content[start : end+1])
+	}
+}
+
+// ownerTxoParams reads the paging query of the owner routes. Tags are comma
+// separated.
+func ownerTxoParams(c *fiber.Ctx) *txostore.OwnerTxoParams {
+	params := &txostore.OwnerTxoParams{
+		Status: c.Query("status"),
+		Cursor: c.Query("cursor"),
+		Limit:  int64(min(c.QueryInt("limit", txostore.DEFAULT_PAGE_SIZE), txostore.MAX_PAGE_SIZE)),
+		Fields: &txostore.LoadTxoParams{
+			Block:  true,
+			Spend:  true,
+			Events: true,
+			Obj:    true,
+			Tags:   store.Tags(),
+		},
+	}
+	if tags := c.Query("tags"); tags != "" {
+		params.Tags = strings.Split(tags, ",")
+	}
+	return params
+}
+
 // HealthCheck godoc
 // @Summary Show the status of server.
 // @Description get the status of server.
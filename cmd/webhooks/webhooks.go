@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/mod"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/txostore"
+	"github.com/shruggr/casemod-indexer/webhook"
+)
+
+var rdb storage.Storage
+
+var cache storage.Storage
+
+var ctx = context.Background()
+
+var store = &txostore.Store{
+	Indexers: mod.Indexers(),
+}
+
+func init() {
+	wd, _ := os.Getwd()
+	log.Println("CWD:", wd)
+	godotenv.Load(fmt.Sprintf(`%s/../../.env`, wd))
+
+	var err error
+	if rdb, err = storage.Open(os.Getenv("REDISDB")); err != nil {
+		panic(err)
+	}
+
+	if cache, err = storage.Open(os.Getenv("REDISCACHE")); err != nil {
+		panic(err)
+	}
+
+	db.Initialize(rdb, cache, 8)
+}
+
+func main() {
+	worker := &webhook.Worker{
+		DB:    rdb,
+		Store: store,
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+	log.Println("Delivering webhooks")
+	if err := worker.Run(ctx); err != nil {
+		log.Panicln(err)
+	}
+}
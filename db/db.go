@@ -3,30 +3,34 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"strconv"
 
 	"github.com/GorillaPool/go-junglebus"
+	"github.com/GorillaPool/go-junglebus/models"
 	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/shruggr/casemod-indexer/storage"
 	"github.com/shruggr/casemod-indexer/types"
-
-	"github.com/redis/go-redis/v9"
 )
 
 // var TRIGGER = uint32(783968)
 
-var Txos *redis.Client
-var Blockchain *redis.Client
+const REORG_PAGE_SIZE = 100
+
+var Txos storage.Storage
+var Blockchain storage.Storage
 var JB *junglebus.Client
 
 var JUNGLEBUS string
 var reqLimiter chan struct{}
 
-func Initialize(txoDb *redis.Client, blockchainDb *redis.Client, concurrentRequests uint8) (err error) {
+func Initialize(txoDb storage.Storage, blockchainDb storage.Storage, concurrentRequests uint8) (err error) {
 	Txos = txoDb
 	Blockchain = blockchainDb
 	reqLimiter = make(chan struct{}, concurrentRequests)
@@ -45,7 +49,10 @@ func Initialize(txoDb *redis.Client, blockchainDb *redis.Client, concurrentReque
 }
 
 func SaveRawtx(ctx context.Context, txid string, rawtx []byte) {
-	if err := Blockchain.HSet(ctx, RawtxKey, txid, rawtx).Err(); err != nil {
+	if err := Blockchain.Write(ctx, func(w storage.Writer) error {
+		w.SaveFields(RawtxKey, map[string][]byte{txid: rawtx})
+		return nil
+	}); err != nil {
 		log.Panicf("SaveTx %s %s", txid, err)
 	}
 }
@@ -54,28 +61,44 @@ func SaveTx(ctx context.Context, tx *transaction.Transaction) {
 	txid := tx.TxID()
 	SaveRawtx(ctx, txid, tx.Bytes())
 	if tx.MerklePath != nil {
-		if err := Blockchain.HSet(ctx, ProofKey, txid, tx.MerklePath.Bytes()).Err(); err != nil {
-			log.Panicln("SaveProof", txid, err)
-		}
+		SaveProof(ctx, txid, tx.MerklePath.Bytes())
+	}
+}
+
+func SaveProof(ctx context.Context, txid string, proof []byte) {
+	if err := Blockchain.Write(ctx, func(w storage.Writer) error {
+		w.SaveFields(ProofKey, map[string][]byte{txid: proof})
+		return nil
+	}); err != nil {
+		log.Panicln("SaveProof", txid, err)
 	}
 }
 
-func LoadTx(ctx context.Context, txid string) (tx *transaction.Transaction, err error) {
-	if rawtx, err := Blockchain.HGet(ctx, RawtxKey, txid).Bytes(); err != nil && err != redis.Nil {
+func DeleteProof(ctx context.Context, txid string) error {
+	return Blockchain.Write(ctx, func(w storage.Writer) error {
+		w.DeleteFields(ProofKey, txid)
+		return nil
+	})
+}
+
+func LoadTx(ctx context.Context, txid string) (*transaction.Transaction, error) {
+	if fields, err := Blockchain.LoadFields(ctx, RawtxKey, txid); err != nil {
 		return nil, err
-	} else if len(rawtx) > 0 {
-		if tx, err = transaction.NewTransactionFromBytes(rawtx); err != nil {
+	} else if rawtx := fields[txid]; len(rawtx) > 0 {
+		if tx, err := transaction.NewTransactionFromBytes(rawtx); err != nil {
 			log.Panicln("NewTransactionFromBytes", txid, err)
+		} else {
+			return tx, nil
 		}
 	}
 	return LoadTxRemote(ctx, txid)
 }
 
 func LoadTxBlock(ctx context.Context, txid string) *types.Block {
-	if score, err := Txos.ZScore(ctx, TxStatusKey, txid).Result(); err != nil && err != redis.Nil {
+	if score, exists, err := Txos.Score(ctx, TxStatusKey, txid); err != nil {
 		log.Panicln("LoadTxBlock", txid, err)
 		return nil
-	} else if err == redis.Nil {
+	} else if !exists {
 		return nil
 	} else {
 		return types.ParseBlockScore(score)
@@ -90,7 +113,9 @@ func LoadTxRemote(ctx context.Context, txid string) (*transaction.Transaction, e
 	if err != nil {
 		log.Println("JB GetRawTransaction", err)
 		return nil, err
-	} else if resp.StatusCode > 200 {
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode > 200 {
 		return nil, fmt.Errorf("JB GetRawTransaction %d %s", resp.StatusCode, txid)
 	} else if rawtx, err := io.ReadAll(resp.Body); err != nil {
 		log.Println("JB ReadRawTransaction", err)
@@ -113,13 +138,22 @@ func LoadTxAndProof(ctx context.Context, txid string) (*transaction.Transaction,
 	}
 }
 
+// LoadProof returns the proof for txid once it has been verified against the
+// stored block header. Proofs which do not match are quarantined and
+// ErrInvalidProof is returned, as it is for a txid whose proof is already in
+// quarantine. Proofs for blocks which have not been synced
+// yet are not cached and the tx is treated as unmined.
 func LoadProof(ctx context.Context, txid string) (*transaction.MerklePath, error) {
 	log.Println("LoadProof", txid)
-	if proof, err := Blockchain.HGet(ctx, ProofKey, txid).Bytes(); err != nil && err != redis.Nil {
+	var proof []byte
+	fetched := false
+	if bad, err := Blockchain.LoadFields(ctx, QuarantineKey, txid); err != nil {
+		return nil, err
+	} else if len(bad[txid]) > 0 {
+		return nil, fmt.Errorf("%w: %s quarantined", ErrInvalidProof, txid)
+	} else if fields, err := Blockchain.LoadFields(ctx, ProofKey, txid); err != nil {
 		return nil, err
-	} else if len(proof) > 0 {
-		return transaction.NewMerklePathFromBinary(proof)
-	} else if JUNGLEBUS != "" {
+	} else if proof = fields[txid]; len(proof) == 0 && JUNGLEBUS != "" {
 		url := fmt.Sprintf("%s/v1/transaction/proof/%s/bin", JUNGLEBUS, txid)
 		// log.Println("JB", url)
 		reqLimiter <- struct{}{}
@@ -127,15 +161,33 @@ func LoadProof(ctx context.Context, txid string) (*transaction.MerklePath, error
 		<-reqLimiter
 		if err != nil {
 			log.Println("JB GetProof", err)
-		} else if resp.StatusCode == 200 {
-			proof, _ = io.ReadAll(resp.Body)
-			if err = Blockchain.HSet(ctx, ProofKey, txid, proof).Err(); err != nil {
-				return nil, err
+		} else {
+			defer resp.Body.Close()
+			if resp.StatusCode == 200 {
+				proof, _ = io.ReadAll(resp.Body)
+				fetched = true
 			}
-			return transaction.NewMerklePathFromBinary(proof)
 		}
 	}
-	return nil, nil
+	if len(proof) == 0 {
+		return nil, nil
+	} else if merklePath, err := transaction.NewMerklePathFromBinary(proof); err != nil {
+		QuarantineProof(ctx, txid, proof)
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProof, err)
+	} else if _, err := VerifyProof(ctx, Headers{}, txid, merklePath); errors.Is(err, ErrHeaderNotFound) {
+		log.Println("LoadProof", txid, err)
+		return nil, nil
+	} else if errors.Is(err, ErrInvalidProof) {
+		QuarantineProof(ctx, txid, proof)
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	} else {
+		if fetched {
+			SaveProof(ctx, txid, proof)
+		}
+		return merklePath, nil
+	}
 }
 
 func SyncBlocks(ctx context.Context, fromHeight uint32, pageSize uint) (uint32, error) {
@@ -145,17 +197,13 @@ func SyncBlocks(ctx context.Context, fromHeight uint32, pageSize uint) (uint32,
 	if err != nil {
 		log.Panicln(err)
 	}
-	if _, err := Txos.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+	if err := Txos.Write(ctx, func(w storage.Writer) error {
 		for _, block := range blocks {
 			if blockData, err := json.Marshal(block); err != nil {
 				return err
-			} else if err := pipe.HSet(ctx, BlockKey, BlockHeightKey(block.Height), blockData).Err(); err != nil {
-				return err
-			} else if err := pipe.ZAdd(ctx, BlockIdKey, redis.Z{
-				Score:  float64(block.Height),
-				Member: block.Hash,
-			}).Err(); err != nil {
-				return err
+			} else {
+				w.SaveFields(BlockKey, map[string][]byte{BlockHeightKey(block.Height): blockData})
+				w.AddMember(BlockIdKey, block.Hash, float64(block.Height))
 			}
 			height = block.Height + 1
 		}
@@ -165,3 +213,72 @@ func SyncBlocks(ctx context.Context, fromHeight uint32, pageSize uint) (uint32,
 	}
 	return height, nil
 }
+
+func LoadBlockHeader(ctx context.Context, height uint32) (*models.BlockHeader, error) {
+	return loadBlockHeader(ctx, Txos, height)
+}
+
+func loadBlockHeader(ctx context.Context, txos storage.Storage, height uint32) (*models.BlockHeader, error) {
+	field := BlockHeightKey(height)
+	if fields, err := txos.LoadFields(ctx, BlockKey, field); err != nil {
+		return nil, err
+	} else if blockData, ok := fields[field]; !ok {
+		return nil, nil
+	} else {
+		header := &models.BlockHeader{}
+		if err := json.Unmarshal(blockData, header); err != nil {
+			return nil, err
+		}
+		return header, nil
+	}
+}
+
+// FindFork walks back from height comparing the headers stored in txos with
+// JungleBus until the hashes agree. It returns the lowest orphaned height, or
+// 0 if the stored chain is still on the main chain.
+func FindFork(ctx context.Context, txos storage.Storage, height uint32) (fork uint32, err error) {
+	for height > 0 {
+		from := uint32(1)
+		if height > REORG_PAGE_SIZE {
+			from = height - REORG_PAGE_SIZE + 1
+		}
+		headers, err := JB.GetBlockHeaders(ctx, strconv.FormatUint(uint64(from), 10), uint(height-from+1))
+		if err != nil {
+			return 0, err
+		}
+		for i := len(headers) - 1; i >= 0; i-- {
+			if stored, err := loadBlockHeader(ctx, txos, headers[i].Height); err != nil {
+				return 0, err
+			} else if stored == nil {
+				continue
+			} else if stored.Hash == headers[i].Hash {
+				return fork, nil
+			}
+			log.Println("Orphaned block", headers[i].Height, headers[i].Hash)
+			fork = headers[i].Height
+		}
+		height = from - 1
+	}
+	return fork, nil
+}
+
+// DeleteBlocks removes the headers stored in txos at or above fromHeight.
+func DeleteBlocks(ctx context.Context, txos storage.Storage, fromHeight uint32) error {
+	orphaned := &storage.ScoreRange{
+		Min: float64(fromHeight),
+		Max: math.Inf(1),
+	}
+	blockIds, err := txos.RangeByScore(ctx, BlockIdKey, orphaned)
+	if err != nil {
+		return err
+	}
+	return txos.Write(ctx, func(w storage.Writer) error {
+		fields := make([]string, 0, len(blockIds))
+		for _, blockId := range blockIds {
+			fields = append(fields, BlockHeightKey(uint32(blockId.Score)))
+		}
+		w.DeleteFields(BlockKey, fields...)
+		w.RemoveRange(BlockIdKey, orphaned)
+		return nil
+	})
+}
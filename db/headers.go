@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/GorillaPool/go-junglebus/models"
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/bitcoin-sv/go-sdk/transaction/chaintracker"
+	"github.com/bitcoin-sv/go-sdk/util"
+	"github.com/shruggr/casemod-indexer/storage"
+)
+
+var ErrInvalidProof = errors.New("merkle proof does not match block header")
+var ErrHeaderNotFound = errors.New("block header not found")
+
+// HeaderSource supplies the block headers merkle proofs are verified against.
+type HeaderSource interface {
+	chaintracker.ChainTracker
+	Header(ctx context.Context, height uint32) (*models.BlockHeader, error)
+}
+
+// Headers is a HeaderSource backed by the block headers saved by SyncBlocks.
+type Headers struct{}
+
+func (Headers) Header(ctx context.Context, height uint32) (*models.BlockHeader, error) {
+	return LoadBlockHeader(ctx, height)
+}
+
+func (h Headers) IsValidRootForHeight(root []byte, height uint32) bool {
+	if header, err := h.Header(context.Background(), height); err != nil {
+		log.Println("LoadBlockHeader", height, err)
+		return false
+	} else if header == nil {
+		return false
+	} else {
+		return header.MerkleRoot == hex.EncodeToString(util.ReverseBytes(root))
+	}
+}
+
+// VerifyProof computes the merkle root of txid from proof and compares it with
+// the header at the proof's height, returning the header if they match.
+func VerifyProof(ctx context.Context, headers HeaderSource, txid string, proof *transaction.MerklePath) (*models.BlockHeader, error) {
+	if root, err := proof.ComputeRoot(&txid); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidProof, err)
+	} else if header, err := headers.Header(ctx, proof.BlockHeight); err != nil {
+		return nil, err
+	} else if header == nil {
+		return nil, fmt.Errorf("%w: %d", ErrHeaderNotFound, proof.BlockHeight)
+	} else if header.MerkleRoot != root {
+		return nil, fmt.Errorf("%w: %s at %d", ErrInvalidProof, txid, proof.BlockHeight)
+	} else {
+		return header, nil
+	}
+}
+
+// QuarantineProof sets aside a proof which failed verification so it is
+// neither served nor fetched again without review.
+func QuarantineProof(ctx context.Context, txid string, proof []byte) {
+	log.Println("Quarantining proof", txid)
+	if err := Blockchain.Write(ctx, func(w storage.Writer) error {
+		w.SaveFields(QuarantineKey, map[string][]byte{txid: proof})
+		w.DeleteFields(ProofKey, txid)
+		return nil
+	}); err != nil {
+		log.Panicln("QuarantineProof", txid, err)
+	}
+}
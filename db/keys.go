@@ -1,6 +1,7 @@
 package db
 
 import (
+	"crypto/rand"
 	"fmt"
 
 	"github.com/shruggr/casemod-indexer/types"
@@ -26,6 +27,14 @@ func OwnerEventKey(owner string, tag string, e *types.EventLog) string {
 // 	return fmt.Sprintf("%s:%s", value, outpoint.String())
 // }
 
+// TempKey returns a unique key for short-lived results, such as combined
+// search sets, which the caller deletes once read.
+func TempKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("tmp:%x", b)
+}
+
 func LogKey(indexer string) string {
 	return fmt.Sprintf("log:%s", indexer)
 }
@@ -39,6 +48,7 @@ var BlockIdKey = "blockid"
 var ProgressKey = "progress"
 var RawtxKey = "rawtx"
 var ProofKey = "proof"
+var QuarantineKey = "qrn"
 
 // var RawtxKey = "rawtx"
 
@@ -64,6 +74,26 @@ var ProofKey = "proof"
 
 var TxStatusKey = "txs"
 
+/*
+ * Webhook Keys
+ */
+var WebhookQueueKey = "whq"
+var WebhookRetryKey = "whr"
+var WebhookProgressKey = "whp"
+
+func WebhookKey(id string) string {
+	return fmt.Sprintf("whk:%s", id)
+}
+
+// WebhookChannelKey holds the ids of the webhooks notified for an event key.
+func WebhookChannelKey(channel string) string {
+	return fmt.Sprintf("whc:%s", channel)
+}
+
+func JournalKey(txid string) string {
+	return fmt.Sprintf("jnl:%s", txid)
+}
+
 /*
  * Txo Keys
  */
@@ -73,8 +103,24 @@ func TxoKey(outpoint *types.Outpoint) string {
 	return TxoPrefix + outpoint.String()
 }
 
+// ConflictKey holds every transaction seen spending outpoint, if more than
+// one has been.
+func ConflictKey(outpoint *types.Outpoint) string {
+	return fmt.Sprintf("cfl:%s", outpoint.String())
+}
+
+// FeeKey holds the fee data of tag for each transaction mined at height, by
+// FeeMember of its block index.
+func FeeKey(tag string, height uint32) string {
+	return fmt.Sprintf("fee:%s:%s", tag, BlockHeightKey(height))
+}
+
+func FeeMember(idx uint64) string {
+	return fmt.Sprintf("%010d", idx)
+}
+
 func TxoTxidKey(txid string) string {
-	return fmt.Sprintf("%s%s*", TxoPrefix, txid)
+	return TxoPrefix + txid
 }
 
 // type TxoMemeber string
@@ -86,6 +132,7 @@ func TxoTxidKey(txid string) string {
 // }
 
 var OutputMember = "out"
+var OwnerMember = "own"
 var SpendMember = "spn"
 var DepSuffix = "dep"
 var EventSuffix = "evt"
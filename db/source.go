@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bitcoin-sv/go-sdk/transaction"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// TxSource supplies the raw transactions and merkle proofs a Store ingests.
+type TxSource interface {
+	LoadTx(ctx context.Context, txid string) (*transaction.Transaction, error)
+	// LoadProof returns nil if txid is not mined.
+	LoadProof(ctx context.Context, txid string) (*transaction.MerklePath, error)
+	// DeleteProof drops a cached proof so the next LoadProof fetches it again.
+	DeleteProof(ctx context.Context, txid string) error
+	// QuarantineProof sets aside a proof which failed verification so
+	// LoadProof no longer serves it.
+	QuarantineProof(ctx context.Context, txid string, proof *transaction.MerklePath)
+	// SaveTx stores tx and its MerklePath, if set, for later loads.
+	SaveTx(ctx context.Context, tx *transaction.Transaction)
+}
+
+// RemoteSource loads transactions from the Blockchain db, falling back to
+// JungleBus.
+type RemoteSource struct{}
+
+func (RemoteSource) LoadTx(ctx context.Context, txid string) (*transaction.Transaction, error) {
+	return LoadTx(ctx, txid)
+}
+
+func (RemoteSource) LoadProof(ctx context.Context, txid string) (*transaction.MerklePath, error) {
+	return LoadProof(ctx, txid)
+}
+
+func (RemoteSource) DeleteProof(ctx context.Context, txid string) error {
+	return DeleteProof(ctx, txid)
+}
+
+func (RemoteSource) QuarantineProof(ctx context.Context, txid string, proof *transaction.MerklePath) {
+	QuarantineProof(ctx, txid, proof.Bytes())
+}
+
+func (RemoteSource) SaveTx(ctx context.Context, tx *transaction.Transaction) {
+	SaveTx(ctx, tx)
+}
+
+// MemorySource serves transactions and proofs added to it and never reaches
+// out to the network.
+type MemorySource struct {
+	mu     sync.RWMutex
+	txs    map[string]*transaction.Transaction
+	proofs map[string]*transaction.MerklePath
+	bad    map[string]*transaction.MerklePath
+}
+
+// NewMemorySource returns a MemorySource holding txs. The MerklePath of each
+// tx, if set, is added as its proof.
+func NewMemorySource(txs ...*transaction.Transaction) *MemorySource {
+	m := &MemorySource{
+		txs:    make(map[string]*transaction.Transaction),
+		proofs: make(map[string]*transaction.MerklePath),
+		bad:    make(map[string]*transaction.MerklePath),
+	}
+	for _, tx := range txs {
+		m.AddTx(tx)
+	}
+	return m
+}
+
+func (m *MemorySource) AddTx(tx *transaction.Transaction) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	txid := tx.TxID()
+	m.txs[txid] = tx
+	if tx.MerklePath != nil {
+		m.proofs[txid] = tx.MerklePath
+	}
+}
+
+func (m *MemorySource) AddProof(txid string, proof *transaction.MerklePath) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.proofs[txid] = proof
+}
+
+// LoadTx returns a copy of the stored transaction without its proof, as
+// LoadTx does for the Blockchain db.
+func (m *MemorySource) LoadTx(ctx context.Context, txid string) (*transaction.Transaction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if tx, ok := m.txs[txid]; !ok {
+		return nil, ErrNotFound
+	} else {
+		return transaction.NewTransactionFromBytes(tx.Bytes())
+	}
+}
+
+func (m *MemorySource) LoadProof(ctx context.Context, txid string) (*transaction.MerklePath, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, ok := m.bad[txid]; ok {
+		return nil, fmt.Errorf("%w: %s quarantined", ErrInvalidProof, txid)
+	}
+	return m.proofs[txid], nil
+}
+
+func (m *MemorySource) DeleteProof(ctx context.Context, txid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.proofs, txid)
+	return nil
+}
+
+func (m *MemorySource) QuarantineProof(ctx context.Context, txid string, proof *transaction.MerklePath) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bad[txid] = proof
+	delete(m.proofs, txid)
+}
+
+func (m *MemorySource) SaveTx(ctx context.Context, tx *transaction.Transaction) {
+	m.AddTx(tx)
+}
@@ -13,8 +13,8 @@ import (
 
 	"github.com/GorillaPool/go-junglebus"
 	"github.com/GorillaPool/go-junglebus/models"
-	"github.com/redis/go-redis/v9"
 	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
 )
 
 const REFRESH = 15 * time.Second
@@ -43,10 +43,15 @@ func Start(
 	}()
 
 	if indexer != "" {
-		if logs, err := db.Txos.XRevRangeN(ctx, db.LogKey(indexer), "+", "-", 1).Result(); err != nil {
+		if logs, err := db.Txos.ReadStream(ctx, db.LogKey(indexer), &storage.StreamRange{
+			Start: "-",
+			Stop:  "+",
+			Count: 1,
+			Rev:   true,
+		}); err != nil {
 			log.Panic(err)
 		} else if len(logs) > 0 {
-			parts := strings.Split(logs[0].ID, "-")
+			parts := strings.Split(logs[0].Id, "-")
 			if height, err := strconv.ParseUint(parts[0], 10, 32); err == nil && height > uint64(progress) {
 				progress = uint(height)
 				lastBlock = uint32(progress)
@@ -113,13 +118,9 @@ func Start(
 				return
 			}
 			// db.SaveRawtx(ctx, txn.Transaction)
-			if err := db.Txos.XAdd(ctx, &redis.XAddArgs{
-				Stream: logKey,
-				Values: map[string]interface{}{
-					"txn": txn.Id,
-				},
-				ID: fmt.Sprintf("%d-%d", txn.BlockHeight, txn.BlockIndex),
-			}).Err(); err != nil {
+			if err := db.Txos.AppendStream(ctx, logKey, fmt.Sprintf("%d-%d", txn.BlockHeight, txn.BlockIndex), map[string]string{
+				"txn": txn.Id,
+			}); err != nil {
 				log.Panicln(err)
 			}
 			lastBlock = txn.BlockHeight
@@ -130,10 +131,10 @@ func Start(
 			if verbose > 0 {
 				log.Printf("[MEM]: %s\n", txn.Id)
 			}
-			if err := db.Txos.ZAdd(ctx, queueKey, redis.Z{
-				Member: txn.Id,
-				Score:  float64(time.Now().Unix()),
-			}).Err(); err != nil {
+			if err := db.Txos.Write(ctx, func(w storage.Writer) error {
+				w.AddMember(queueKey, txn.Id, float64(time.Now().Unix()))
+				return nil
+			}); err != nil {
 				log.Panicln(err)
 			}
 		},
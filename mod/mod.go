@@ -0,0 +1,33 @@
+// Package mod lists the indexers every binary registers, so txos ingested by
+// one are read back with the same tags by the others.
+package mod
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/shruggr/casemod-indexer/mod/bsv21"
+	"github.com/shruggr/casemod-indexer/mod/ord"
+	"github.com/shruggr/casemod-indexer/mod/ordlock"
+	"github.com/shruggr/casemod-indexer/mod/p2pkh"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+// Indexers returns the indexers in the order they run: inscriptions are
+// parsed before the BSV-21 indexer reads them, and BSV-21 tokens before
+// OrdLock prices them. The P2PKH indexer, which indexes every address
+// payment, is only registered when the INDEX_P2PKH environment variable is
+// true, and should be set alike for every binary sharing a store.
+func Indexers() []types.Indexer {
+	indexers := []types.Indexer{
+		&ord.SatIndexer{},
+		&ord.OriginIndexer{},
+		&ord.InscriptionIndexer{},
+		&bsv21.Bsv21Indexer{},
+		&ordlock.OrdLockIndexer{},
+	}
+	if index, _ := strconv.ParseBool(os.Getenv("INDEX_P2PKH")); index {
+		indexers = append(indexers, &p2pkh.P2pkhIndexer{})
+	}
+	return indexers
+}
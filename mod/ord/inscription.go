@@ -3,8 +3,13 @@ package ord
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"regexp"
 	"slices"
+	"strconv"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/bitcoin-sv/go-sdk/script"
@@ -14,6 +19,14 @@ import (
 )
 
 var AsciiRegexp = regexp.MustCompile(`^[[:ascii:]]*$`)
+var wordSplitter = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// MAX_TEXT_SIZE is the largest inscription decoded into Text or Json.
+const MAX_TEXT_SIZE = 1024
+
+// MAX_WORDS bounds the word events of one inscription.
+const MAX_WORDS = 256
+const MAX_WORD_LENGTH = 64
 
 type File struct {
 	Content []byte         `json:"content"`
@@ -22,14 +35,45 @@ type File struct {
 	Hash    lib.ByteString `json:"hash"`
 }
 
-// type Field struct {
-// 	Id    []byte `json:"id"`
-// 	Value []byte `json:"value"`
-// }
+// MAPPrefix is the bitcom protocol address of MAP.
+const MAPPrefix = "1PuQa7K62MiKCtssSLKy1kh56WWU7MtUR5"
+
+// Envelope field tags, as numbered by the ordinals protocol.
+const (
+	FieldContent         = 0
+	FieldContentType     = 1
+	FieldPointer         = 2
+	FieldParent          = 3
+	FieldMetadata        = 5
+	FieldMetaprotocol    = 7
+	FieldContentEncoding = 9
+	FieldDelegate        = 11
+)
 
+// Inscription holds the fields of an ord envelope. Fields keeps the tags
+// without a field of their own: multi-byte tags, such as bitcom protocol
+// addresses, by their text and unknown numeric tags by their number. MAP
+// fields are also decoded into Map.
 type Inscription struct {
-	File   *File           `json:"file"`
-	Parent *types.Outpoint `json:"parent"`
+	File            *File                     `json:"file"`
+	Parent          *types.Outpoint           `json:"parent"`
+	Pointer         *uint64                   `json:"pointer,omitempty"`
+	Metadata        lib.ByteString            `json:"metadata,omitempty"`
+	Metaprotocol    string                    `json:"metaprotocol,omitempty"`
+	ContentEncoding string                    `json:"contentEncoding,omitempty"`
+	Delegate        *types.Outpoint           `json:"delegate,omitempty"`
+	Fields          map[string]lib.ByteString `json:"fields,omitempty"`
+	Map             map[string]string         `json:"map,omitempty"`
+	Text            string                    `json:"text,omitempty"`
+	Json            json.RawMessage           `json:"json,omitempty"`
+}
+
+func (i *Inscription) OmitContent() {
+	if i.File != nil {
+		i.File.Content = nil
+	}
+	i.Text = ""
+	i.Json = nil
 }
 
 type InscriptionIndexer struct {
@@ -97,33 +141,42 @@ ordLoop:
 		} else if len(op.Data) == 1 {
 			field = int(op.Data[0])
 		} else if len(op.Data) > 1 {
-			// TODO: Handle bitcom
-
-			// ins.Fields = append(ins.Fields, &Field{
-			// 	Id:    op.Data,
-			// 	Value: op2.Data,
-			// })
+			ins.setField(envelopeTag(op.Data), op2.Data)
 			continue
 		}
 
 		switch field {
-		case 0:
+		case FieldContent:
 			ins.File.Content = op2.Data
 			ins.File.Size = uint32(len(ins.File.Content))
 			hash := sha256.Sum256(ins.File.Content)
 			ins.File.Hash = hash[:]
 			break ordLoop
-		case 1:
+		case FieldContentType:
 			if len(op2.Data) < 256 && utf8.Valid(op2.Data) {
 				ins.File.Type = string(op2.Data)
 			}
-		case 3:
-			ins.Parent = types.NewOutpointFromBytes(op2.Data)
+		case FieldPointer:
+			if len(op2.Data) <= 8 {
+				pointer := binary.LittleEndian.Uint64(append(bytes.Clone(op2.Data), make([]byte, 8-len(op2.Data))...))
+				ins.Pointer = &pointer
+			}
+		case FieldParent:
+			ins.Parent = inscriptionId(op2.Data)
+		case FieldMetadata:
+			ins.Metadata = append(ins.Metadata, op2.Data...)
+		case FieldMetaprotocol:
+			if len(op2.Data) < 256 && utf8.Valid(op2.Data) {
+				ins.Metaprotocol = string(op2.Data)
+			}
+		case FieldContentEncoding:
+			if len(op2.Data) < 256 && utf8.Valid(op2.Data) {
+				ins.ContentEncoding = string(op2.Data)
+			}
+		case FieldDelegate:
+			ins.Delegate = inscriptionId(op2.Data)
 		default:
-			// ins.Fields = append(ins.Fields, &Field{
-			// 	Id:    []byte{byte(field)},
-			// 	Value: op2.Data,
-			// })
+			ins.setField(strconv.Itoa(field), op2.Data)
 		}
 	}
 	op, err := s.ReadOp(&pos)
@@ -146,15 +199,70 @@ ordLoop:
 	return idxData
 }
 
+// envelopeTag names a multi-byte envelope tag by its text, or in hex if it
+// is not valid UTF-8.
+func envelopeTag(tag []byte) string {
+	if utf8.Valid(tag) {
+		return string(tag)
+	}
+	return hex.EncodeToString(tag)
+}
+
+// inscriptionId reads an inscription id, a txid followed by an index with
+// its trailing zero bytes trimmed.
+func inscriptionId(b []byte) *types.Outpoint {
+	if len(b) < 32 || len(b) > 36 {
+		return nil
+	}
+	return types.NewOutpointFromBytes(append(bytes.Clone(b), make([]byte, 36-len(b))...))
+}
+
+func (ins *Inscription) setField(tag string, value []byte) {
+	if ins.Fields == nil {
+		ins.Fields = make(map[string]lib.ByteString)
+	}
+	ins.Fields[tag] = value
+	if tag == MAPPrefix {
+		ins.Map = parseMap(value)
+	}
+}
+
+// parseMap decodes a MAP SET, a series of pushes "SET" key value key value...
+func parseMap(value []byte) map[string]string {
+	ops, err := script.NewFromBytes(value).ParseOps()
+	if err != nil || len(ops) < 3 || string(ops[0].Data) != "SET" {
+		return nil
+	}
+	m := make(map[string]string)
+	for i := 1; i+1 < len(ops); i += 2 {
+		if key, val := ops[i].Data, ops[i+1].Data; len(key) <= 256 && len(val) <= 1024 && utf8.Valid(key) && utf8.Valid(val) {
+			m[string(key)] = string(val)
+		}
+	}
+	return m
+}
+
 func (i *InscriptionIndexer) IndexInscription(idxCtx *types.IndexContext, idxData *types.IndexData) {
 	ins := idxData.Obj.(*Inscription)
 	idxData.Events = append(idxData.Events, &types.EventLog{
 		Label: "type",
 		Value: ins.File.Type,
 	})
+	if ins.Metaprotocol != "" {
+		idxData.Events = append(idxData.Events, &types.EventLog{
+			Label: "metaprotocol",
+			Value: ins.Metaprotocol,
+		})
+	}
+	if ins.Delegate != nil {
+		idxData.Events = append(idxData.Events, &types.EventLog{
+			Label: "delegate",
+			Value: ins.Delegate.String(),
+		})
+	}
 	if ins.Parent != nil {
 		if slices.ContainsFunc(idxCtx.Spends, func(spend *types.Txo) bool {
-			if o, ok := spend.Data["origin"]; ok {
+			if o, ok := spend.Data[ORIGIN_TAG]; ok {
 				if origin, ok := o.Obj.(*Origin); ok {
 					return bytes.Equal(origin.Outpoint.Bytes(), ins.Parent.Bytes())
 				}
@@ -169,35 +277,78 @@ func (i *InscriptionIndexer) IndexInscription(idxCtx *types.IndexContext, idxDat
 			ins.Parent = nil
 		}
 	}
-	// if ins.File.Size <= 1024 && utf8.Valid(ins.File.Content) && !bytes.Contains(ins.File.Content, []byte{0}) && !bytes.Contains(ins.File.Content, []byte("\\u0000")) {
-	// 	mime := strings.ToLower(ins.File.Type)
-	// 	if strings.HasPrefix(mime, "application") ||
-	// 		strings.HasPrefix(mime, "text") {
-	// 		var data json.RawMessage
-	// 		err := json.Unmarshal(ins.File.Content, &data)
-	// 		if err == nil {
-	// 			// TODO:  raise events
-	// 		} else if AsciiRegexp.Match(ins.File.Content) {
-	// 			text := string(ins.File.Content)
-	// 			re := regexp.MustCompile(`\W`)
-	// 			words := map[string]struct{}{}
-	// 			for _, word := range re.Split(text, -1) {
-	// 				if len(word) > 0 {
-	// 					word = strings.ToLower(word)
-	// 					words[word] = struct{}{}
-	// 				}
-	// 			}
-	// 			if len(words) > 0 {
-	// 				for word := range words {
-	// 					idxData.Events = append(idxData.Events, &types.Event{
-	// 						Id:    "word",
-	// 						Value: word,
-	// 					})
-	// 				}
-	// 			}
-	// 		}
-	// 	}
-	// }
+	if text := ins.decodeText(); text != "" {
+		for _, word := range Words(text) {
+			idxData.Events = append(idxData.Events, &types.EventLog{
+				Label:  "word",
+				Value:  word,
+				Search: true,
+			})
+		}
+	}
+}
+
+// decodeText fills in Json and Text for small, uncompressed text and JSON
+// inscriptions, returning the text to index. For JSON that is every string
+// in the document.
+func (ins *Inscription) decodeText() string {
+	content := ins.File.Content
+	if len(content) == 0 || len(content) > MAX_TEXT_SIZE || ins.ContentEncoding != "" ||
+		!utf8.Valid(content) || bytes.Contains(content, []byte{0}) {
+		return ""
+	}
+	mime := strings.ToLower(ins.File.Type)
+	if strings.HasPrefix(mime, "application") && json.Valid(content) {
+		var doc any
+		if err := json.Unmarshal(content, &doc); err != nil {
+			return ""
+		}
+		ins.Json = json.RawMessage(content)
+		return strings.Join(jsonStrings(doc, nil), " ")
+	} else if strings.HasPrefix(mime, "text") {
+		ins.Text = string(content)
+		return ins.Text
+	}
+	return ""
+}
+
+func jsonStrings(v any, strs []string) []string {
+	switch v := v.(type) {
+	case string:
+		strs = append(strs, v)
+	case []any:
+		for _, item := range v {
+			strs = jsonStrings(item, strs)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			strs = jsonStrings(v[key], strs)
+		}
+	}
+	return strs
+}
+
+// Words splits text into its distinct lower case words, in order of first
+// appearance, up to MAX_WORDS.
+func Words(text string) []string {
+	words := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, word := range wordSplitter.Split(strings.ToLower(text), -1) {
+		if word == "" || len(word) > MAX_WORD_LENGTH {
+			continue
+		} else if _, ok := seen[word]; !ok {
+			seen[word] = struct{}{}
+			if words = append(words, word); len(words) == MAX_WORDS {
+				break
+			}
+		}
+	}
+	return words
 }
 
 func (i *InscriptionIndexer) UnmarshalData(raw []byte) (any, error) {
@@ -0,0 +1,168 @@
+package ord
+
+import (
+	"bytes"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bitcoin-sv/go-sdk/script"
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/bitcoin-sv/go-sdk/transaction/template/p2pkh"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+const testAddress = "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"
+
+// envelope builds a 1 sat output inscribing content, preceded by the given
+// tag and value pairs, and locked to testAddress.
+func envelope(t *testing.T, content string, fields ...[]byte) *types.IndexContext {
+	s := &script.Script{}
+	s.AppendOpcodes(script.Op0, script.OpIF)
+	s.AppendPushData([]byte("ord"))
+	for _, field := range fields {
+		s.AppendPushData(field)
+	}
+	s.AppendOpcodes(script.Op0)
+	s.AppendPushData([]byte(content))
+	s.AppendOpcodes(script.OpENDIF)
+	address, err := script.NewAddressFromString(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock, err := p2pkh.Lock(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	*s = append(*s, *lock...)
+
+	tx := transaction.NewTransaction()
+	tx.AddOutput(&transaction.TransactionOutput{Satoshis: 1, LockingScript: s})
+	return &types.IndexContext{
+		Tx: tx,
+		Txos: []*types.Txo{{
+			Outpoint: &types.Outpoint{Txid: tx.TxIDBytes()},
+			Output:   &types.Output{Satoshis: 1, Script: *s},
+		}},
+	}
+}
+
+func TestParseInscription(t *testing.T) {
+	txid := bytes.Repeat([]byte{0xab}, 32)
+	mapSet := &script.Script{}
+	mapSet.AppendPushDataStrings([]string{"SET", "app", "test", "type", "post"})
+
+	for _, tt := range []struct {
+		name    string
+		content string
+		fields  [][]byte
+		check   func(ins *Inscription) bool
+	}{
+		{"content", "Hello", [][]byte{{FieldContentType}, []byte("text/plain")}, func(ins *Inscription) bool {
+			return ins.File.Type == "text/plain" && string(ins.File.Content) == "Hello" &&
+				ins.File.Size == 5 && len(ins.File.Hash) == 32 && ins.Text == "Hello"
+		}},
+		{"json", `{"b":"two","a":"one"}`, [][]byte{{FieldContentType}, []byte("application/json")}, func(ins *Inscription) bool {
+			return string(ins.Json) == `{"b":"two","a":"one"}` && ins.Text == ""
+		}},
+		{"pointer", "", [][]byte{{FieldPointer}, {0x01, 0x02}}, func(ins *Inscription) bool {
+			return ins.Pointer != nil && *ins.Pointer == 0x0201
+		}},
+		{"parent", "", [][]byte{{FieldParent}, append(bytes.Clone(txid), 1)}, func(ins *Inscription) bool {
+			return ins.Parent != nil && ins.Parent.Vout == 1 && bytes.Equal(ins.Parent.Txid, txid)
+		}},
+		{"parent not spent", "", [][]byte{{FieldParent}, append(bytes.Clone(txid), 2)}, func(ins *Inscription) bool {
+			return ins.Parent == nil
+		}},
+		{"chunked metadata", "", [][]byte{{FieldMetadata}, []byte("ab"), {FieldMetadata}, []byte("cd")}, func(ins *Inscription) bool {
+			return string(ins.Metadata) == "abcd"
+		}},
+		{"metaprotocol and encoding", "Hello", [][]byte{
+			{FieldContentType}, []byte("text/plain"),
+			{FieldMetaprotocol}, []byte("bsv-20"),
+			{FieldContentEncoding}, []byte("br"),
+		}, func(ins *Inscription) bool {
+			return ins.Metaprotocol == "bsv-20" && ins.ContentEncoding == "br" && ins.Text == ""
+		}},
+		{"delegate", "", [][]byte{{FieldDelegate}, txid}, func(ins *Inscription) bool {
+			return ins.Delegate != nil && ins.Delegate.Vout == 0 && bytes.Equal(ins.Delegate.Txid, txid)
+		}},
+		{"unknown tag", "", [][]byte{{13}, []byte("x")}, func(ins *Inscription) bool {
+			return len(ins.Fields) == 1 && string(ins.Fields["13"]) == "x"
+		}},
+		{"binary tag", "", [][]byte{{0xff, 0xfe}, []byte("x")}, func(ins *Inscription) bool {
+			return string(ins.Fields["fffe"]) == "x"
+		}},
+		{"MAP", "", [][]byte{[]byte(MAPPrefix), *mapSet}, func(ins *Inscription) bool {
+			return reflect.DeepEqual(ins.Map, map[string]string{"app": "test", "type": "post"})
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			idxCtx := envelope(t, tt.content, tt.fields...)
+			idxCtx.Spends = []*types.Txo{{
+				Data: map[string]*types.IndexData{
+					ORIGIN_TAG: {Obj: &Origin{Outpoint: &types.Outpoint{Txid: txid, Vout: 1}}},
+				},
+			}}
+			idxData := (&InscriptionIndexer{}).Parse(idxCtx, 0)
+			if idxData == nil {
+				t.Fatal("no inscription")
+			}
+			ins := idxData.Obj.(*Inscription)
+			if !tt.check(ins) {
+				t.Fatalf("unexpected inscription %+v", ins)
+			} else if owner := idxCtx.Txos[0].Owner; owner == nil || owner.Address() != testAddress {
+				t.Fatalf("owner %v", owner)
+			}
+		})
+	}
+}
+
+func TestOmitContent(t *testing.T) {
+	for _, tt := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain", "Hello"},
+		{"application/json", `{"a":"one"}`},
+	} {
+		idxData := (&InscriptionIndexer{}).Parse(envelope(t, tt.content, []byte{FieldContentType}, []byte(tt.contentType)), 0)
+		ins := idxData.Obj.(*Inscription)
+		ins.OmitContent()
+		if ins.File.Content != nil || ins.Text != "" || ins.Json != nil {
+			t.Fatalf("%s content left %+v", tt.contentType, ins)
+		} else if ins.File.Size != uint32(len(tt.content)) {
+			t.Fatalf("size %d", ins.File.Size)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	long := string(bytes.Repeat([]byte("a"), MAX_WORD_LENGTH+1))
+	many := make([]string, 0, MAX_WORDS+10)
+	for i := 0; i < MAX_WORDS+10; i++ {
+		many = append(many, "w"+strconv.Itoa(i))
+	}
+	for _, tt := range []struct {
+		name  string
+		text  string
+		want  []string
+		count int
+	}{
+		{"splits on punctuation", "Hello, World! hello-world", []string{"hello", "world"}, 2},
+		{"keeps letters and numbers", "Ünïcode 21e8 日本", []string{"ünïcode", "21e8", "日本"}, 3},
+		{"skips long words", "short " + long, []string{"short"}, 1},
+		{"empty", " ,. ", []string{}, 0},
+		{"caps the word count", strings.Join(many, " "), many[:MAX_WORDS], MAX_WORDS},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			words := Words(tt.text)
+			if len(words) != tt.count {
+				t.Fatalf("%d words, want %d", len(words), tt.count)
+			} else if tt.want != nil && !reflect.DeepEqual(words, tt.want) {
+				t.Fatalf("got %v, want %v", words, tt.want)
+			}
+		})
+	}
+}
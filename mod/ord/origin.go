@@ -1,9 +1,105 @@
 package ord
 
-import "github.com/shruggr/casemod-indexer/types"
+import (
+	"log"
+
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/shruggr/casemod-indexer/types"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const ORIGIN_TAG = "origin"
 
 type Origin struct {
 	Outpoint *types.Outpoint   `json:"outpoint,omitempty"`
 	Nonce    uint32            `json:"nonce,omitempty"`
 	Map      map[string]string `json:"map,omitempty"`
 }
+
+// OriginIndexer follows 1 sat outputs back to the first 1 sat output holding
+// the same satoshi, their origin. Nonce counts the transfers since then.
+// Origins are carried forward from the spent txo, so a 1 sat output whose
+// ancestry could not be ingested, such as one beyond the Store's
+// AncestryDepth, has no origin.
+type OriginIndexer struct {
+	types.BaseIndexer
+}
+
+func (o *OriginIndexer) Tag() string {
+	return ORIGIN_TAG
+}
+
+func (o *OriginIndexer) Parse(idxCtx *types.IndexContext, vout uint32) *types.IndexData {
+	txo := idxCtx.Txos[vout]
+	if txo.Output.Satoshis != 1 {
+		return nil
+	}
+	origin := &Origin{Outpoint: txo.Outpoint}
+	vin, _ := satInput(idxCtx.Tx, vout, func(vin int) (uint64, error) {
+		return idxCtx.Spends[vin].Output.Satoshis, nil
+	})
+	if vin >= 0 {
+		spend := idxCtx.Spends[vin]
+		data, ok := spend.Data[ORIGIN_TAG]
+		if !ok || data.Obj == nil {
+			log.Println("Origin unknown", txo.Outpoint.String())
+			return nil
+		}
+		prior := data.Obj.(*Origin)
+		origin.Outpoint = prior.Outpoint
+		origin.Nonce = prior.Nonce + 1
+	}
+	return &types.IndexData{
+		Obj: origin,
+		Events: []*types.EventLog{
+			{
+				Label: ORIGIN_TAG,
+				Value: origin.Outpoint.String(),
+			},
+		},
+	}
+}
+
+func (o *OriginIndexer) Save(idxCtx *types.IndexContext) {}
+
+// NeedsAncestor asks for the ancestry of every 1 sat output to be ingested so
+// its origin can be carried forward.
+func (o *OriginIndexer) NeedsAncestor(output *transaction.TransactionOutput) bool {
+	return output.Satoshis == 1
+}
+
+func (o *OriginIndexer) UnmarshalData(raw []byte) (any, error) {
+	origin := &Origin{}
+	if err := msgpack.Unmarshal(raw, origin); err != nil {
+		return nil, err
+	} else {
+		return origin, nil
+	}
+}
+
+// satInput returns the input of tx which supplies the first satoshi of
+// output vout, as long as it is a 1 sat input, or -1.
+func satInput(tx *transaction.Transaction, vout uint32, inputSats func(vin int) (uint64, error)) (int, error) {
+	if tx.IsCoinbase() {
+		return -1, nil
+	}
+	offset := uint64(0)
+	for _, output := range tx.Outputs[:vout] {
+		offset += output.Satoshis
+	}
+	inSat := uint64(0)
+	for vin := range tx.Inputs {
+		sats, err := inputSats(vin)
+		if err != nil {
+			return -1, err
+		} else if inSat == offset {
+			if sats == 1 {
+				return vin, nil
+			}
+			return -1, nil
+		} else if inSat += sats; inSat > offset {
+			return -1, nil
+		}
+	}
+	return -1, nil
+}
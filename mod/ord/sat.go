@@ -0,0 +1,188 @@
+package ord
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/shruggr/casemod-indexer/types"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const SAT_TAG = "sat"
+const INITIAL_SUBSIDY = 50 * 100_000_000
+const HALVING_INTERVAL = 210_000
+
+// unknownStart marks the sats of an input whose ranges are unknown.
+const unknownStart = math.MaxUint64
+
+// SatRange is Size consecutive satoshis starting at ordinal Start.
+type SatRange struct {
+	Start uint64 `json:"start"`
+	Size  uint64 `json:"size"`
+}
+
+type Sats struct {
+	Ranges []*SatRange `json:"ranges"`
+}
+
+// SatIndexer assigns ordinal numbers to the satoshis of every output. A
+// coinbase mints its block subsidy, followed by the fees of the other
+// transactions of its block in block order, and every other transaction
+// passes the sats of its inputs, in order, to its outputs and then its fee.
+// Outputs whose sats come from an input with unknown ranges, or from fees not
+// yet ingested, are not indexed. One sat outputs also emit a sat event,
+// linking every origin of the same ordinal. Ranges are only known if ingest
+// starts from the genesis block and covers every transaction of each block.
+type SatIndexer struct {
+	types.BaseIndexer
+}
+
+func (i *SatIndexer) Tag() string {
+	return SAT_TAG
+}
+
+func (i *SatIndexer) Parse(idxCtx *types.IndexContext, vout uint32) *types.IndexData {
+	offset := uint64(0)
+	for _, output := range idxCtx.Tx.Outputs[:vout] {
+		offset += output.Satoshis
+	}
+	size := idxCtx.Tx.Outputs[vout].Satoshis
+	if size == 0 {
+		return nil
+	}
+	ranges := sliceRanges(inputRanges(idxCtx), offset, size)
+	if ranges == nil {
+		return nil
+	}
+	idxData := &types.IndexData{
+		Obj: &Sats{Ranges: ranges},
+	}
+	if size == 1 {
+		idxData.Events = append(idxData.Events, &types.EventLog{
+			Label: SAT_TAG,
+			Value: strconv.FormatUint(ranges[0].Start, 10),
+		})
+	}
+	return idxData
+}
+
+func (i *SatIndexer) Save(idxCtx *types.IndexContext) {}
+
+// Fee returns the sats left over once the outputs have taken theirs, which
+// are passed on to the coinbase. Fees from inputs with unknown ranges are
+// kept as an unknown range of the same size, holding the place of the fees
+// which follow.
+func (i *SatIndexer) Fee(idxCtx *types.IndexContext) *types.IndexData {
+	if idxCtx.Tx.IsCoinbase() {
+		return nil
+	}
+	ranges := inputRanges(idxCtx)
+	in := uint64(0)
+	for _, r := range ranges {
+		in += r.Size
+	}
+	out := uint64(0)
+	for _, output := range idxCtx.Tx.Outputs {
+		out += output.Satoshis
+	}
+	if in <= out {
+		return nil
+	}
+	fee := sliceRanges(ranges, out, in-out)
+	if fee == nil {
+		fee = []*SatRange{{Start: unknownStart, Size: in - out}}
+	}
+	return &types.IndexData{
+		Obj: &Sats{Ranges: fee},
+	}
+}
+
+func (i *SatIndexer) UnmarshalData(raw []byte) (any, error) {
+	sats := &Sats{}
+	if err := msgpack.Unmarshal(raw, sats); err != nil {
+		return nil, err
+	} else {
+		return sats, nil
+	}
+}
+
+// Subsidy is the number of sats minted by the coinbase at height.
+func Subsidy(height uint32) uint64 {
+	if halvings := height / HALVING_INTERVAL; halvings >= 64 {
+		return 0
+	} else {
+		return INITIAL_SUBSIDY >> halvings
+	}
+}
+
+// FirstOrdinal is the ordinal of the first sat minted at height.
+func FirstOrdinal(height uint32) uint64 {
+	first := uint64(0)
+	for era := uint32(0); era < height/HALVING_INTERVAL && era < 64; era++ {
+		first += HALVING_INTERVAL * (INITIAL_SUBSIDY >> era)
+	}
+	return first + uint64(height%HALVING_INTERVAL)*Subsidy(height)
+}
+
+// inputRanges lists the sats entering the transaction in order, with the
+// sats of inputs whose ranges are unknown starting at unknownStart. Those
+// entering a coinbase are its subsidy and the fees recorded for its block.
+func inputRanges(idxCtx *types.IndexContext) []*SatRange {
+	if idxCtx.Tx.IsCoinbase() {
+		block := types.ParseBlockScore(types.BlockScore(idxCtx.Block))
+		if block == nil {
+			return nil
+		}
+		ranges := make([]*SatRange, 0, 1+len(idxCtx.Fees[SAT_TAG]))
+		if subsidy := Subsidy(block.Height); subsidy > 0 {
+			ranges = append(ranges, &SatRange{Start: FirstOrdinal(block.Height), Size: subsidy})
+		}
+		for _, fee := range idxCtx.Fees[SAT_TAG] {
+			if sats, ok := fee.Obj.(*Sats); ok {
+				ranges = append(ranges, sats.Ranges...)
+			}
+		}
+		return ranges
+	}
+	ranges := make([]*SatRange, 0, len(idxCtx.Spends))
+	for _, spend := range idxCtx.Spends {
+		if data, ok := spend.Data[SAT_TAG]; ok && data.Obj != nil {
+			ranges = append(ranges, data.Obj.(*Sats).Ranges...)
+		} else if spend.Output.Satoshis > 0 {
+			ranges = append(ranges, &SatRange{Start: unknownStart, Size: spend.Output.Satoshis})
+		}
+	}
+	return ranges
+}
+
+// sliceRanges returns the size sats starting offset sats into ranges,
+// merging adjacent ranges, or nil if any of them are unknown.
+func sliceRanges(ranges []*SatRange, offset uint64, size uint64) []*SatRange {
+	sliced := make([]*SatRange, 0, 1)
+	pos := uint64(0)
+	for _, r := range ranges {
+		if pos+r.Size <= offset {
+			pos += r.Size
+			continue
+		} else if r.Start == unknownStart {
+			return nil
+		}
+		start := r.Start
+		available := r.Size
+		if pos < offset {
+			start += offset - pos
+			available -= offset - pos
+		}
+		take := min(available, size)
+		if last := len(sliced) - 1; last >= 0 && sliced[last].Start+sliced[last].Size == start {
+			sliced[last].Size += take
+		} else {
+			sliced = append(sliced, &SatRange{Start: start, Size: take})
+		}
+		if size -= take; size == 0 {
+			return sliced
+		}
+		pos += r.Size
+	}
+	return nil
+}
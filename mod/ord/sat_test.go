@@ -0,0 +1,71 @@
+package ord
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSliceRanges(t *testing.T) {
+	ranges := []*SatRange{
+		{Start: 100, Size: 10},
+		{Start: 110, Size: 5},
+		{Start: 500, Size: 10},
+		{Start: unknownStart, Size: 10},
+		{Start: 900, Size: 10},
+	}
+	for _, tt := range []struct {
+		name   string
+		offset uint64
+		size   uint64
+		want   []*SatRange
+	}{
+		{"first sat", 0, 1, []*SatRange{{Start: 100, Size: 1}}},
+		{"inside a range", 3, 4, []*SatRange{{Start: 103, Size: 4}}},
+		{"merges adjacent ranges", 5, 8, []*SatRange{{Start: 105, Size: 8}}},
+		{"spans a gap", 12, 6, []*SatRange{{Start: 112, Size: 3}, {Start: 500, Size: 3}}},
+		{"ends at an unknown range", 20, 5, []*SatRange{{Start: 505, Size: 5}}},
+		{"overlaps an unknown range", 24, 2, nil},
+		{"after an unknown range", 35, 5, []*SatRange{{Start: 900, Size: 5}}},
+		{"past the inputs", 45, 1, nil},
+		{"runs into fee sats", 43, 5, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sliceRanges(ranges, tt.offset, tt.size); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", describe(got), describe(tt.want))
+			}
+		})
+	}
+}
+
+func describe(ranges []*SatRange) []SatRange {
+	if ranges == nil {
+		return nil
+	}
+	out := make([]SatRange, 0, len(ranges))
+	for _, r := range ranges {
+		out = append(out, *r)
+	}
+	return out
+}
+
+func TestFirstOrdinal(t *testing.T) {
+	for _, tt := range []struct {
+		height  uint32
+		subsidy uint64
+		first   uint64
+	}{
+		{0, 50_0000_0000, 0},
+		{1, 50_0000_0000, 50_0000_0000},
+		{HALVING_INTERVAL - 1, 50_0000_0000, (HALVING_INTERVAL - 1) * 50_0000_0000},
+		{HALVING_INTERVAL, 25_0000_0000, HALVING_INTERVAL * 50_0000_0000},
+		{HALVING_INTERVAL + 2, 25_0000_0000, HALVING_INTERVAL*50_0000_0000 + 2*25_0000_0000},
+		{64 * HALVING_INTERVAL, 0, 2099999997690000},
+	} {
+		if subsidy := Subsidy(tt.height); subsidy != tt.subsidy {
+			t.Errorf("Subsidy(%d) = %d, want %d", tt.height, subsidy, tt.subsidy)
+		}
+		if first := FirstOrdinal(tt.height); first != tt.first {
+			t.Errorf("FirstOrdinal(%d) = %d, want %d", tt.height, first, tt.first)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package p2pkh
+
+import (
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+const TAG = "p2pkh"
+
+// P2pkhIndexer indexes plain 25 byte pay-to-public-key-hash outputs so every
+// spendable output of an address is stored, even if no other indexer claims
+// it.
+type P2pkhIndexer struct {
+	types.BaseIndexer
+}
+
+func (p *P2pkhIndexer) Tag() string {
+	return TAG
+}
+
+func (p *P2pkhIndexer) Parse(idxCtx *types.IndexContext, vout uint32) *types.IndexData {
+	txo := idxCtx.Txos[vout]
+	if txo.Owner == nil || len(txo.Output.Script) != 25 {
+		return nil
+	}
+	return &types.IndexData{
+		Events: []*types.EventLog{
+			AddressEvent(txo.Owner.Address()),
+		},
+	}
+}
+
+func (p *P2pkhIndexer) Save(idxCtx *types.IndexContext) {}
+
+func (p *P2pkhIndexer) UnmarshalData(raw []byte) (any, error) {
+	return nil, nil
+}
+
+func AddressEvent(address string) *types.EventLog {
+	return &types.EventLog{
+		Label: "address",
+		Value: address,
+	}
+}
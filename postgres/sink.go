@@ -0,0 +1,373 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shruggr/casemod-indexer/mod/bsv21"
+	"github.com/shruggr/casemod-indexer/mod/ord"
+	"github.com/shruggr/casemod-indexer/mod/ordlock"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+// Sink mirrors ingested transactions into the tables defined in migrations/.
+// Outpoints are stored as the txid in display order followed by the vout as
+// a big-endian uint32, matching the generated txid and vout columns of txos.
+//
+// blocks gets a row for every block a transaction is seen mined in; its fees
+// are filled in from the coinbase. Rows of orphaned blocks are left in place,
+// as txns refer to blocks by id. The bsv20 v1 tables and progress are not
+// written, since BSV-20 v1 tokens are not indexed.
+type Sink struct {
+	Db *pgxpool.Pool
+}
+
+func NewSink(db *pgxpool.Pool) *Sink {
+	return &Sink{Db: db}
+}
+
+func (s *Sink) Ingest(ctx context.Context, idxCtx *types.IndexContext) error {
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	height, idx := blockPosition(idxCtx.Block)
+	batch := &pgx.Batch{}
+
+	var blockId []byte
+	if height != nil && len(idxCtx.Block.Hash) > 0 {
+		blockId = idxCtx.Block.Hash
+	}
+	var fees *int64
+	var inacc uint64
+	for _, spend := range idxCtx.Spends {
+		inacc += spend.Output.Satoshis
+	}
+	var outacc uint64
+	for _, output := range idxCtx.Tx.Outputs {
+		outacc += output.Satoshis
+	}
+	if !idxCtx.Tx.IsCoinbase() {
+		fee := int64(inacc) - int64(outacc)
+		fees = &fee
+	}
+	if blockId != nil {
+		var blockFees *int64
+		if idxCtx.Tx.IsCoinbase() {
+			fee := int64(outacc) - int64(ord.Subsidy(*height))
+			blockFees = &fee
+		}
+		batch.Queue(`INSERT INTO blocks(id, height, subsidy, subacc, fees)
+			VALUES($1, $2, $3, $4, $5)
+			ON CONFLICT(id) DO UPDATE SET
+				fees=COALESCE(EXCLUDED.fees, blocks.fees)`,
+			blockId,
+			height,
+			ord.Subsidy(*height),
+			ord.FirstOrdinal(*height),
+			blockFees,
+		)
+	}
+	batch.Queue(`INSERT INTO txns(txid, block_id, height, idx, ins, outs, fees)
+		VALUES($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT(txid) DO UPDATE SET
+			block_id=EXCLUDED.block_id,
+			height=EXCLUDED.height,
+			idx=EXCLUDED.idx,
+			fees=EXCLUDED.fees`,
+		[]byte(idxCtx.Txid),
+		blockId,
+		height,
+		idx,
+		len(idxCtx.Tx.Inputs),
+		len(idxCtx.Tx.Outputs),
+		fees,
+	)
+
+	inacc = 0
+	for vin, spend := range idxCtx.Spends {
+		var owner []byte
+		if spend.Owner != nil {
+			owner = *spend.Owner
+		}
+		batch.Queue(`INSERT INTO txos(outpoint, satoshis, pkhash, spend, vin, spend_height, spend_idx, inacc)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT(outpoint) DO UPDATE SET
+				spend=EXCLUDED.spend,
+				vin=EXCLUDED.vin,
+				spend_height=EXCLUDED.spend_height,
+				spend_idx=EXCLUDED.spend_idx,
+				inacc=EXCLUDED.inacc`,
+			outpointBytes(spend.Outpoint),
+			spend.Output.Satoshis,
+			owner,
+			[]byte(idxCtx.Txid),
+			vin,
+			height,
+			idx,
+			inacc,
+		)
+		inacc += spend.Output.Satoshis
+
+		if _, ok := spend.Data["list"]; ok {
+			sale := isSale(idxCtx, spend)
+			batch.Queue(`UPDATE listings
+				SET spend_height=$3, spend_idx=$4, sale=$5
+				WHERE txid=$1 AND vout=$2`,
+				[]byte(spend.Outpoint.Txid),
+				spend.Outpoint.Vout,
+				height,
+				idx,
+				sale,
+			)
+			if _, ok := spend.Data["bsv21"]; ok {
+				batch.Queue(`UPDATE bsv20_txos
+					SET spend_height=$3, spend_idx=$4, sale=$5
+					WHERE txid=$1 AND vout=$2`,
+					[]byte(spend.Outpoint.Txid),
+					spend.Outpoint.Vout,
+					height,
+					idx,
+					sale,
+				)
+			}
+		} else if _, ok := spend.Data["bsv21"]; ok {
+			batch.Queue(`UPDATE bsv20_txos
+				SET spend_height=$3, spend_idx=$4
+				WHERE txid=$1 AND vout=$2`,
+				[]byte(spend.Outpoint.Txid),
+				spend.Outpoint.Vout,
+				height,
+				idx,
+			)
+		}
+	}
+
+	outacc = 0
+	for _, txo := range idxCtx.Txos {
+		satoshis := txo.Output.Satoshis
+		acc := outacc
+		outacc += satoshis
+		if len(txo.Data) == 0 {
+			continue
+		}
+		var owner []byte
+		if txo.Owner != nil {
+			owner = *txo.Owner
+		}
+		data, err := json.Marshal(txo.Data)
+		if err != nil {
+			return err
+		}
+		var origin *ord.Origin
+		if o, ok := txo.Data[ord.ORIGIN_TAG]; ok {
+			origin, _ = o.Obj.(*ord.Origin)
+		}
+		var originId []byte
+		if origin != nil {
+			originId = outpointBytes(origin.Outpoint)
+		}
+		batch.Queue(`INSERT INTO txos(outpoint, height, idx, satoshis, outacc, pkhash, origin, data)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT(outpoint) DO UPDATE SET
+				height=EXCLUDED.height,
+				idx=EXCLUDED.idx,
+				satoshis=EXCLUDED.satoshis,
+				outacc=EXCLUDED.outacc,
+				pkhash=EXCLUDED.pkhash,
+				origin=EXCLUDED.origin,
+				data=EXCLUDED.data`,
+			outpointBytes(txo.Outpoint),
+			height,
+			idx,
+			satoshis,
+			acc,
+			owner,
+			originId,
+			data,
+		)
+
+		// An origin is created by its first output, and takes on the MAP
+		// fields of each inscription made on the ordinal since
+		var originMap []byte
+		if insc, ok := txo.Data["insc"]; ok {
+			if ins, ok := insc.Obj.(*ord.Inscription); ok && ins.Map != nil {
+				if originMap, err = json.Marshal(ins.Map); err != nil {
+					return err
+				}
+			}
+		}
+		if origin != nil && (origin.Nonce == 0 || originMap != nil) {
+			batch.Queue(`INSERT INTO origins(origin, map)
+				VALUES($1, $2)
+				ON CONFLICT(origin) DO UPDATE SET
+					map=COALESCE(origins.map, '{}'::jsonb) || COALESCE(EXCLUDED.map, '{}'::jsonb)`,
+				originId,
+				originMap,
+			)
+		}
+
+		if _, ok := txo.Data["insc"]; ok && height != nil {
+			batch.Queue(`INSERT INTO inscriptions(height, idx, vout)
+				VALUES($1, $2, $3)
+				ON CONFLICT DO NOTHING`,
+				height,
+				idx,
+				txo.Outpoint.Vout,
+			)
+		}
+
+		var listing *ordlock.Listing
+		if list, ok := txo.Data["list"]; ok {
+			listing, _ = list.Obj.(*ordlock.Listing)
+		}
+		if listing != nil {
+			_, isBsv21 := txo.Data["bsv21"]
+			batch.Queue(`INSERT INTO listings(txid, vout, height, idx, price, payout, pkhash, data, bsv20)
+				VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+				ON CONFLICT(txid, vout) DO UPDATE SET
+					height=EXCLUDED.height,
+					idx=EXCLUDED.idx,
+					price=EXCLUDED.price,
+					payout=EXCLUDED.payout,
+					pkhash=EXCLUDED.pkhash,
+					data=EXCLUDED.data`,
+				[]byte(txo.Outpoint.Txid),
+				txo.Outpoint.Vout,
+				height,
+				idx,
+				listing.Price,
+				listing.PayOut,
+				owner,
+				data,
+				isBsv21,
+			)
+		}
+
+		if b, ok := txo.Data["bsv21"]; ok {
+			if token, ok := b.Obj.(*bsv21.Bsv21); ok && token.Op == "deploy+mint" {
+				var icon []byte
+				if token.Icon != nil {
+					icon = outpointBytes(token.Icon)
+				}
+				batch.Queue(`INSERT INTO bsv20_v2(id, height, idx, sym, icon, amt, dec)
+					VALUES($1, $2, $3, $4, $5, $6, $7)
+					ON CONFLICT(id) DO UPDATE SET
+						height=EXCLUDED.height,
+						idx=EXCLUDED.idx`,
+					outpointBytes(token.Id),
+					height,
+					idx,
+					token.Sym,
+					icon,
+					token.Amt,
+					token.Dec,
+				)
+			}
+			// BSV-21 tokens are identified by id alone; tick only applies
+			// to BSV-20 v1 tokens, so it is written empty
+			if token, ok := b.Obj.(*bsv21.Bsv21); ok {
+				var price *uint64
+				var payout []byte
+				var pricePer *float64
+				if listing != nil {
+					price = &listing.Price
+					payout = listing.PayOut
+					pricePer = &listing.PricePer
+				}
+				batch.Queue(`INSERT INTO bsv20_txos(txid, vout, height, idx, id, tick, op, amt, pkhash, status, reason, listing, price, payout, price_per_token, script)
+					VALUES($1, $2, $3, $4, $5, '', $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+					ON CONFLICT(txid, vout) DO UPDATE SET
+						height=EXCLUDED.height,
+						idx=EXCLUDED.idx,
+						pkhash=EXCLUDED.pkhash,
+						status=EXCLUDED.status,
+						reason=EXCLUDED.reason,
+						listing=EXCLUDED.listing,
+						price=EXCLUDED.price,
+						payout=EXCLUDED.payout,
+						price_per_token=EXCLUDED.price_per_token`,
+					[]byte(txo.Outpoint.Txid),
+					txo.Outpoint.Vout,
+					height,
+					idx,
+					outpointBytes(token.Id),
+					token.Op,
+					token.Amt,
+					owner,
+					token.Status,
+					token.Reason,
+					listing != nil,
+					price,
+					payout,
+					pricePer,
+					txo.Output.Script,
+				)
+			}
+		}
+	}
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Revert removes the rows written for txid and clears the spends it made.
+// MAP fields merged into an older origin are kept.
+func (s *Sink) Revert(ctx context.Context, txid string) error {
+	txidBytes, err := hex.DecodeString(txid)
+	if err != nil {
+		return err
+	}
+	tx, err := s.Db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	batch := &pgx.Batch{}
+	batch.Queue(`UPDATE listings SET spend_height=NULL, spend_idx=NULL, sale=NULL
+		WHERE spend=$1`, txidBytes)
+	batch.Queue(`UPDATE bsv20_txos SET spend_height=NULL, spend_idx=NULL, sale=NULL
+		WHERE spend=$1`, txidBytes)
+	batch.Queue(`UPDATE txos SET spend='\x', vin=NULL, spend_height=NULL, spend_idx=NULL, inacc=NULL
+		WHERE spend=$1`, txidBytes)
+	batch.Queue(`DELETE FROM inscriptions
+		WHERE (height, idx) IN (SELECT height, idx FROM txns WHERE txid=$1)`, txidBytes)
+	batch.Queue(`DELETE FROM bsv20_v2 WHERE txid=$1`, txidBytes)
+	batch.Queue(`DELETE FROM origins WHERE substring(origin from 1 for 32)=$1`, txidBytes)
+	batch.Queue(`DELETE FROM txos WHERE txid=$1`, txidBytes)
+	batch.Queue(`DELETE FROM txns WHERE txid=$1`, txidBytes)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// blockPosition returns the height and index of a mined block, or nils for
+// mempool transactions, which are stored with a NULL height.
+func blockPosition(block *types.Block) (*uint32, *uint64) {
+	if block == nil || block.Height >= 0x1FFFFF {
+		return nil, nil
+	}
+	return &block.Height, &block.Idx
+}
+
+func outpointBytes(outpoint *types.Outpoint) []byte {
+	return binary.BigEndian.AppendUint32(bytes.Clone(outpoint.Txid), outpoint.Vout)
+}
+
+// isSale reports whether the spending transaction pays the listing's payout
+// output, rather than the seller cancelling the listing.
+func isSale(idxCtx *types.IndexContext, spend *types.Txo) bool {
+	listing, ok := spend.Data["list"].Obj.(*ordlock.Listing)
+	return ok && listing.PaidBy(idxCtx.Tx)
+}
@@ -0,0 +1,533 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	valueBucket  = []byte("s")
+	hashBucket   = []byte("h")
+	scoreBucket  = []byte("z")
+	memberBucket = []byte("m")
+	streamBucket = []byte("x")
+)
+
+// BoltStorage is an embedded on-disk Storage. Each key is a nested bucket:
+// hashes map field to value, sorted sets are kept twice, once ordered by
+// score and once by member, and streams are ordered by id. Published
+// messages only reach subscribers in the same process.
+type BoltStorage struct {
+	DB     *bolt.DB
+	broker broker
+}
+
+func OpenBolt(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{valueBucket, hashBucket, scoreBucket, memberBucket, streamBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{DB: db}, nil
+}
+
+func (b *BoltStorage) Get(ctx context.Context, key string) (value []byte, err error) {
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		value, err = boltReader{tx: tx}.Get(ctx, key)
+		return err
+	})
+	return
+}
+
+func (b *BoltStorage) LoadFields(ctx context.Context, key string, fields ...string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(fields))
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(hashBucket).Bucket([]byte(key))
+		if bucket == nil {
+			return nil
+		} else if len(fields) == 0 {
+			return bucket.ForEach(func(k, v []byte) error {
+				result[string(k)] = clone(v)
+				return nil
+			})
+		}
+		for _, field := range fields {
+			if v := bucket.Get([]byte(field)); v != nil {
+				result[field] = clone(v)
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (b *BoltStorage) Lookup(ctx context.Context, lookups []*Lookup) error {
+	return b.DB.View(func(tx *bolt.Tx) error {
+		return boltReader{tx: tx}.Lookup(ctx, lookups)
+	})
+}
+
+func (b *BoltStorage) Score(ctx context.Context, key string, member string) (score float64, exists bool, err error) {
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		score, exists = memberScore(tx, key, member)
+		return nil
+	})
+	return
+}
+
+func (b *BoltStorage) RangeByScore(ctx context.Context, key string, r *ScoreRange) (members []*Member, err error) {
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		members = rangeByScore(tx, key, r)
+		return nil
+	})
+	return
+}
+
+func (b *BoltStorage) Count(ctx context.Context, key string, r *ScoreRange) (count int64, err error) {
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		count = int64(len(rangeByScore(tx, key, &ScoreRange{
+			Min:          r.Min,
+			Max:          r.Max,
+			MinExclusive: r.MinExclusive,
+			MaxExclusive: r.MaxExclusive,
+		})))
+		return nil
+	})
+	return
+}
+
+func (b *BoltStorage) Combine(ctx context.Context, dest string, op SetOp, keys ...string) (count int64, err error) {
+	err = b.DB.Update(func(tx *bolt.Tx) error {
+		sets := make([]map[string]float64, 0, len(keys))
+		for _, key := range keys {
+			set := make(map[string]float64)
+			if bucket := tx.Bucket(memberBucket).Bucket([]byte(key)); bucket != nil {
+				if err := bucket.ForEach(func(k, v []byte) error {
+					set[string(k)] = decodeScore(v)
+					return nil
+				}); err != nil {
+					return err
+				}
+			}
+			sets = append(sets, set)
+		}
+		result := combineSets(op, sets)
+		w := &boltWriter{tx: tx}
+		w.Delete(dest)
+		for member, score := range result {
+			w.AddMember(dest, member, score)
+		}
+		count = int64(len(result))
+		return w.err
+	})
+	return
+}
+
+func (b *BoltStorage) Keys(ctx context.Context, prefix string) ([]string, error) {
+	seen := map[string]struct{}{}
+	keys := make([]string, 0)
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{valueBucket, hashBucket, scoreBucket, streamBucket} {
+			c := tx.Bucket(name).Cursor()
+			for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+				if _, ok := seen[string(k)]; !ok {
+					seen[string(k)] = struct{}{}
+					keys = append(keys, string(k))
+				}
+			}
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (b *BoltStorage) AppendStream(ctx context.Context, stream string, id string, values map[string]string) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(streamBucket).CreateBucketIfNotExists([]byte(stream))
+		if err != nil {
+			return err
+		}
+		var idKey []byte
+		if id == "*" {
+			ms := uint64(time.Now().UnixMilli())
+			seq := uint64(0)
+			if last, _ := bucket.Cursor().Last(); last != nil && binary.BigEndian.Uint64(last[:8]) >= ms {
+				ms = binary.BigEndian.Uint64(last[:8])
+				seq = binary.BigEndian.Uint64(last[8:]) + 1
+			}
+			idKey = binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, ms), seq)
+		} else if idKey, err = encodeStreamId(id, false); err != nil {
+			return err
+		}
+		if last, _ := bucket.Cursor().Last(); last != nil && bytes.Compare(idKey, last) <= 0 {
+			return fmt.Errorf("stream id %s is not greater than the last entry", id)
+		}
+		return bucket.Put(idKey, encodeValues(values))
+	})
+}
+
+func (b *BoltStorage) ReadStream(ctx context.Context, stream string, r *StreamRange) (entries []*StreamEntry, err error) {
+	start, err := encodeStreamId(r.Start, false)
+	if err != nil {
+		return nil, err
+	}
+	stop, err := encodeStreamId(r.Stop, true)
+	if err != nil {
+		return nil, err
+	}
+	err = b.DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(streamBucket).Bucket([]byte(stream))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		var k, v []byte
+		if r.Rev {
+			if k, v = c.Seek(stop); k == nil {
+				k, v = c.Last()
+			} else if bytes.Compare(k, stop) > 0 {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Seek(start)
+		}
+		for ; k != nil; k, v = step(c, r.Rev) {
+			if bytes.Compare(k, start) < 0 || bytes.Compare(k, stop) > 0 {
+				break
+			}
+			entries = append(entries, &StreamEntry{
+				Id:     fmt.Sprintf("%d-%d", binary.BigEndian.Uint64(k[:8]), binary.BigEndian.Uint64(k[8:])),
+				Values: decodeValues(v),
+			})
+			if r.Count > 0 && int64(len(entries)) >= r.Count {
+				break
+			}
+		}
+		return nil
+	})
+	return
+}
+
+func (b *BoltStorage) TrimStream(ctx context.Context, stream string, minId string) error {
+	floor, err := encodeStreamId(minId, false)
+	if err != nil {
+		return err
+	}
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(streamBucket).Bucket([]byte(stream))
+		if bucket == nil {
+			return nil
+		}
+		trimmed := make([][]byte, 0)
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, floor) < 0; k, _ = c.Next() {
+			trimmed = append(trimmed, k)
+		}
+		for _, k := range trimmed {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *BoltStorage) Publish(ctx context.Context, channel string, message string) error {
+	b.broker.publish(channel, message)
+	return nil
+}
+
+func (b *BoltStorage) Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
+	return b.broker.subscribe(ctx, channels), nil
+}
+
+func (b *BoltStorage) Write(ctx context.Context, fn func(w Writer) error) error {
+	return b.Update(ctx, func(r Reader, w Writer) error {
+		return fn(w)
+	})
+}
+
+// Update runs fn in a single read-write transaction. Bolt allows one writer
+// at a time, so fn is never retried.
+func (b *BoltStorage) Update(ctx context.Context, fn func(r Reader, w Writer) error) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		w := &boltWriter{tx: tx}
+		if err := fn(boltReader{tx: tx}, w); err != nil {
+			return err
+		}
+		return w.err
+	})
+}
+
+func (b *BoltStorage) Close() error {
+	return b.DB.Close()
+}
+
+type boltReader struct {
+	tx *bolt.Tx
+}
+
+func (r boltReader) Get(ctx context.Context, key string) ([]byte, error) {
+	return clone(r.tx.Bucket(valueBucket).Get([]byte(key))), nil
+}
+
+func (r boltReader) Lookup(ctx context.Context, lookups []*Lookup) error {
+	for _, l := range lookups {
+		if l.Member {
+			l.Score, l.Exists = memberScore(r.tx, l.Key, l.Field)
+		} else if bucket := r.tx.Bucket(hashBucket).Bucket([]byte(l.Key)); bucket != nil {
+			if v := bucket.Get([]byte(l.Field)); v != nil {
+				l.Exists = true
+				l.Value = clone(v)
+			}
+		}
+	}
+	return nil
+}
+
+type boltWriter struct {
+	tx  *bolt.Tx
+	err error
+}
+
+func (w *boltWriter) bucket(parent []byte, key string) *bolt.Bucket {
+	if w.err != nil {
+		return nil
+	}
+	bucket, err := w.tx.Bucket(parent).CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		w.err = err
+	}
+	return bucket
+}
+
+func (w *boltWriter) check(err error) {
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *boltWriter) Set(key string, value []byte) {
+	w.check(w.tx.Bucket(valueBucket).Put([]byte(key), value))
+}
+
+func (w *boltWriter) Delete(key string) {
+	w.check(w.tx.Bucket(valueBucket).Delete([]byte(key)))
+	for _, name := range [][]byte{hashBucket, scoreBucket, memberBucket, streamBucket} {
+		if err := w.tx.Bucket(name).DeleteBucket([]byte(key)); err != bolt.ErrBucketNotFound {
+			w.check(err)
+		}
+	}
+}
+
+func (w *boltWriter) SaveFields(key string, fields map[string][]byte) {
+	if bucket := w.bucket(hashBucket, key); bucket != nil {
+		for field, value := range fields {
+			w.check(bucket.Put([]byte(field), value))
+		}
+	}
+}
+
+func (w *boltWriter) DeleteFields(key string, fields ...string) {
+	if bucket := w.tx.Bucket(hashBucket).Bucket([]byte(key)); bucket != nil {
+		for _, field := range fields {
+			w.check(bucket.Delete([]byte(field)))
+		}
+	}
+}
+
+func (w *boltWriter) AddMember(key string, member string, score float64) {
+	w.RemoveMember(key, member)
+	scores := w.bucket(scoreBucket, key)
+	members := w.bucket(memberBucket, key)
+	if scores != nil && members != nil {
+		w.check(scores.Put(append(encodeScore(score), member...), []byte{}))
+		w.check(members.Put([]byte(member), encodeScore(score)))
+	}
+}
+
+func (w *boltWriter) RemoveMember(key string, member string) {
+	scores := w.tx.Bucket(scoreBucket).Bucket([]byte(key))
+	members := w.tx.Bucket(memberBucket).Bucket([]byte(key))
+	if scores == nil || members == nil {
+		return
+	}
+	if prev := members.Get([]byte(member)); prev != nil {
+		w.check(scores.Delete(append(clone(prev), member...)))
+		w.check(members.Delete([]byte(member)))
+	}
+}
+
+func (w *boltWriter) RemoveRange(key string, r *ScoreRange) {
+	for _, m := range rangeByScore(w.tx, key, r) {
+		w.RemoveMember(key, m.Member)
+	}
+}
+
+func memberScore(tx *bolt.Tx, key string, member string) (float64, bool) {
+	if bucket := tx.Bucket(memberBucket).Bucket([]byte(key)); bucket != nil {
+		if v := bucket.Get([]byte(member)); v != nil {
+			return decodeScore(v), true
+		}
+	}
+	return 0, false
+}
+
+func rangeByScore(tx *bolt.Tx, key string, r *ScoreRange) []*Member {
+	members := make([]*Member, 0)
+	bucket := tx.Bucket(scoreBucket).Bucket([]byte(key))
+	if bucket == nil {
+		return members
+	}
+	c := bucket.Cursor()
+	var k []byte
+	if r.Rev {
+		if k, _ = c.Seek(encodeScore(math.Nextafter(r.Max, math.Inf(1)))); k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+	} else {
+		k, _ = c.Seek(encodeScore(r.Min))
+	}
+	skipped := int64(0)
+	for ; k != nil; k, _ = step(c, r.Rev) {
+		score := decodeScore(k[:8])
+		if !r.Contains(score) {
+			if (r.Rev && score < r.Min) || (!r.Rev && score > r.Max) {
+				break
+			}
+			continue
+		}
+		if skipped < r.Offset {
+			skipped++
+			continue
+		}
+		members = append(members, &Member{
+			Member: string(k[8:]),
+			Score:  score,
+		})
+		if r.Count > 0 && int64(len(members)) >= r.Count {
+			break
+		}
+	}
+	return members
+}
+
+func step(c *bolt.Cursor, rev bool) ([]byte, []byte) {
+	if rev {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+// encodeScore maps a float64 onto 8 bytes which sort in the same order.
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	if score >= 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return binary.BigEndian.AppendUint64(nil, bits)
+}
+
+func decodeScore(b []byte) float64 {
+	bits := binary.BigEndian.Uint64(b)
+	if bits&(1<<63) != 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+func encodeStreamId(id string, upper bool) ([]byte, error) {
+	var ms, seq uint64
+	exclusive := false
+	if rest, ok := strings.CutPrefix(id, "("); ok {
+		id = rest
+		exclusive = true
+	}
+	switch id {
+	case "-", "":
+	case "+":
+		ms, seq = math.MaxUint64, math.MaxUint64
+	default:
+		var err error
+		parts := strings.SplitN(id, "-", 2)
+		if ms, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid stream id %s", id)
+		}
+		if len(parts) == 2 {
+			if seq, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid stream id %s", id)
+			}
+		} else if upper {
+			seq = math.MaxUint64
+		}
+	}
+	if exclusive {
+		if upper {
+			if seq > 0 {
+				seq--
+			} else {
+				ms, seq = ms-1, math.MaxUint64
+			}
+		} else if seq < math.MaxUint64 {
+			seq++
+		} else {
+			ms, seq = ms+1, 0
+		}
+	}
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, ms), seq), nil
+}
+
+func encodeValues(values map[string]string) []byte {
+	buf := make([]byte, 0)
+	for k, v := range values {
+		buf = binary.AppendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+	}
+	return buf
+}
+
+func decodeValues(buf []byte) map[string]string {
+	values := make(map[string]string)
+	for len(buf) > 0 {
+		kl, n := binary.Uvarint(buf)
+		k := string(buf[n : n+int(kl)])
+		buf = buf[n+int(kl):]
+		vl, n := binary.Uvarint(buf)
+		values[k] = string(buf[n : n+int(vl)])
+		buf = buf[n+int(vl):]
+	}
+	return values
+}
+
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
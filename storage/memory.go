@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	id     []byte
+	values map[string]string
+}
+
+// MemoryStorage keeps everything in process memory. It is meant for tests
+// and short-lived tools; nothing is persisted.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	values  map[string][]byte
+	hashes  map[string]map[string][]byte
+	sets    map[string]map[string]float64
+	streams map[string][]*memoryEntry
+	broker  broker
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		values:  make(map[string][]byte),
+		hashes:  make(map[string]map[string][]byte),
+		sets:    make(map[string]map[string]float64),
+		streams: make(map[string][]*memoryEntry),
+	}
+}
+
+func (m *MemoryStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return memoryReader{m: m}.Get(ctx, key)
+}
+
+func (m *MemoryStorage) LoadFields(ctx context.Context, key string, fields ...string) (map[string][]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	result := make(map[string][]byte, len(fields))
+	hash := m.hashes[key]
+	if len(fields) == 0 {
+		for field, value := range hash {
+			result[field] = clone(value)
+		}
+	}
+	for _, field := range fields {
+		if value, ok := hash[field]; ok {
+			result[field] = clone(value)
+		}
+	}
+	return result, nil
+}
+
+func (m *MemoryStorage) Lookup(ctx context.Context, lookups []*Lookup) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return memoryReader{m: m}.Lookup(ctx, lookups)
+}
+
+func (m *MemoryStorage) Score(ctx context.Context, key string, member string) (float64, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	score, ok := m.sets[key][member]
+	return score, ok, nil
+}
+
+func (m *MemoryStorage) RangeByScore(ctx context.Context, key string, r *ScoreRange) ([]*Member, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.rangeByScore(key, r), nil
+}
+
+func (m *MemoryStorage) rangeByScore(key string, r *ScoreRange) []*Member {
+	members := make([]*Member, 0)
+	for member, score := range m.sets[key] {
+		if r.Contains(score) {
+			members = append(members, &Member{Member: member, Score: score})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score != r.Rev
+		}
+		return members[i].Member < members[j].Member != r.Rev
+	})
+	if r.Offset >= int64(len(members)) {
+		return members[:0]
+	}
+	members = members[r.Offset:]
+	if r.Count > 0 && r.Count < int64(len(members)) {
+		members = members[:r.Count]
+	}
+	return members
+}
+
+func (m *MemoryStorage) Count(ctx context.Context, key string, r *ScoreRange) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var count int64
+	for _, score := range m.sets[key] {
+		if r.Contains(score) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStorage) Combine(ctx context.Context, dest string, op SetOp, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sets := make([]map[string]float64, 0, len(keys))
+	for _, key := range keys {
+		sets = append(sets, m.sets[key])
+	}
+	result := combineSets(op, sets)
+	delete(m.values, dest)
+	delete(m.hashes, dest)
+	delete(m.streams, dest)
+	if len(result) == 0 {
+		delete(m.sets, dest)
+	} else {
+		m.sets[dest] = result
+	}
+	return int64(len(result)), nil
+}
+
+func (m *MemoryStorage) Keys(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	keys := make([]string, 0)
+	for key := range m.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.hashes {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.sets {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.streams {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *MemoryStorage) AppendStream(ctx context.Context, stream string, id string, values map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries := m.streams[stream]
+	var last []byte
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].id
+	}
+	var idKey []byte
+	if id == "*" {
+		ms := uint64(time.Now().UnixMilli())
+		seq := uint64(0)
+		if last != nil && binary.BigEndian.Uint64(last[:8]) >= ms {
+			ms = binary.BigEndian.Uint64(last[:8])
+			seq = binary.BigEndian.Uint64(last[8:]) + 1
+		}
+		idKey = binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, ms), seq)
+	} else if key, err := encodeStreamId(id, false); err != nil {
+		return err
+	} else {
+		idKey = key
+	}
+	if last != nil && bytes.Compare(idKey, last) <= 0 {
+		return fmt.Errorf("stream id %s is not greater than the last entry", id)
+	}
+	entry := &memoryEntry{
+		id:     idKey,
+		values: make(map[string]string, len(values)),
+	}
+	for k, v := range values {
+		entry.values[k] = v
+	}
+	m.streams[stream] = append(entries, entry)
+	return nil
+}
+
+func (m *MemoryStorage) ReadStream(ctx context.Context, stream string, r *StreamRange) ([]*StreamEntry, error) {
+	start, err := encodeStreamId(r.Start, false)
+	if err != nil {
+		return nil, err
+	}
+	stop, err := encodeStreamId(r.Stop, true)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	stored := m.streams[stream]
+	entries := make([]*StreamEntry, 0)
+	for i := range stored {
+		e := stored[i]
+		if r.Rev {
+			e = stored[len(stored)-1-i]
+		}
+		if bytes.Compare(e.id, start) < 0 || bytes.Compare(e.id, stop) > 0 {
+			continue
+		}
+		entry := &StreamEntry{
+			Id:     fmt.Sprintf("%d-%d", binary.BigEndian.Uint64(e.id[:8]), binary.BigEndian.Uint64(e.id[8:])),
+			Values: make(map[string]string, len(e.values)),
+		}
+		for k, v := range e.values {
+			entry.Values[k] = v
+		}
+		entries = append(entries, entry)
+		if r.Count > 0 && int64(len(entries)) >= r.Count {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (m *MemoryStorage) TrimStream(ctx context.Context, stream string, minId string) error {
+	floor, err := encodeStreamId(minId, false)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := m.streams[stream]
+	i := 0
+	for i < len(stored) && bytes.Compare(stored[i].id, floor) < 0 {
+		i++
+	}
+	m.streams[stream] = stored[i:]
+	return nil
+}
+
+func (m *MemoryStorage) Publish(ctx context.Context, channel string, message string) error {
+	m.broker.publish(channel, message)
+	return nil
+}
+
+func (m *MemoryStorage) Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
+	return m.broker.subscribe(ctx, channels), nil
+}
+
+// Write buffers the changes made by fn and applies them only if it returns
+// without error.
+func (m *MemoryStorage) Write(ctx context.Context, fn func(w Writer) error) error {
+	w := &memoryWriter{m: m}
+	if err := fn(w); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, op := range w.ops {
+		op()
+	}
+	return nil
+}
+
+// Update holds the write lock while fn runs, so fn is never retried.
+func (m *MemoryStorage) Update(ctx context.Context, fn func(r Reader, w Writer) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := &memoryWriter{m: m}
+	if err := fn(memoryReader{m: m}, w); err != nil {
+		return err
+	}
+	for _, op := range w.ops {
+		op()
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+// memoryReader reads without locking, for callers already holding the lock.
+type memoryReader struct {
+	m *MemoryStorage
+}
+
+func (r memoryReader) Get(ctx context.Context, key string) ([]byte, error) {
+	return clone(r.m.values[key]), nil
+}
+
+func (r memoryReader) Lookup(ctx context.Context, lookups []*Lookup) error {
+	for _, l := range lookups {
+		if l.Member {
+			l.Score, l.Exists = r.m.sets[l.Key][l.Field]
+		} else if value, ok := r.m.hashes[l.Key][l.Field]; ok {
+			l.Exists = true
+			l.Value = clone(value)
+		}
+	}
+	return nil
+}
+
+type memoryWriter struct {
+	m   *MemoryStorage
+	ops []func()
+}
+
+func (w *memoryWriter) Set(key string, value []byte) {
+	value = clone(value)
+	w.ops = append(w.ops, func() {
+		w.m.values[key] = value
+	})
+}
+
+func (w *memoryWriter) Delete(key string) {
+	w.ops = append(w.ops, func() {
+		delete(w.m.values, key)
+		delete(w.m.hashes, key)
+		delete(w.m.sets, key)
+		delete(w.m.streams, key)
+	})
+}
+
+func (w *memoryWriter) SaveFields(key string, fields map[string][]byte) {
+	values := make(map[string][]byte, len(fields))
+	for field, value := range fields {
+		values[field] = clone(value)
+	}
+	w.ops = append(w.ops, func() {
+		hash := w.m.hashes[key]
+		if hash == nil {
+			hash = make(map[string][]byte, len(values))
+			w.m.hashes[key] = hash
+		}
+		for field, value := range values {
+			hash[field] = value
+		}
+	})
+}
+
+func (w *memoryWriter) DeleteFields(key string, fields ...string) {
+	w.ops = append(w.ops, func() {
+		hash := w.m.hashes[key]
+		for _, field := range fields {
+			delete(hash, field)
+		}
+		if hash != nil && len(hash) == 0 {
+			delete(w.m.hashes, key)
+		}
+	})
+}
+
+func (w *memoryWriter) AddMember(key string, member string, score float64) {
+	w.ops = append(w.ops, func() {
+		set := w.m.sets[key]
+		if set == nil {
+			set = make(map[string]float64)
+			w.m.sets[key] = set
+		}
+		set[member] = score
+	})
+}
+
+func (w *memoryWriter) RemoveMember(key string, member string) {
+	w.ops = append(w.ops, func() {
+		if set := w.m.sets[key]; set != nil {
+			delete(set, member)
+			if len(set) == 0 {
+				delete(w.m.sets, key)
+			}
+		}
+	})
+}
+
+func (w *memoryWriter) RemoveRange(key string, r *ScoreRange) {
+	w.ops = append(w.ops, func() {
+		set := w.m.sets[key]
+		for member, score := range set {
+			if r.Contains(score) {
+				delete(set, member)
+			}
+		}
+		if set != nil && len(set) == 0 {
+			delete(w.m.sets, key)
+		}
+	})
+}
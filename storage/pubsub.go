@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+type Message struct {
+	Channel string `json:"channel"`
+	Payload string `json:"payload"`
+}
+
+// broker fans messages out to subscribers in the same process, for the
+// storages that have no pub/sub of their own. Subscribers that fall behind
+// miss messages rather than block the publisher.
+type broker struct {
+	mu   sync.Mutex
+	subs map[string]map[chan *Message]struct{}
+}
+
+func (b *broker) publish(channel string, message string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subs[channel] {
+		select {
+		case sub <- &Message{Channel: channel, Payload: message}:
+		default:
+		}
+	}
+}
+
+func (b *broker) subscribe(ctx context.Context, channels []string) <-chan *Message {
+	sub := make(chan *Message, 100)
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = make(map[string]map[chan *Message]struct{})
+	}
+	for _, channel := range channels {
+		if b.subs[channel] == nil {
+			b.subs[channel] = make(map[chan *Message]struct{})
+		}
+		b.subs[channel][sub] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for _, channel := range channels {
+			delete(b.subs[channel], sub)
+			if len(b.subs[channel]) == 0 {
+				delete(b.subs, channel)
+			}
+		}
+		close(sub)
+	}()
+	return sub
+}
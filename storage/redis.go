@@ -0,0 +1,344 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisStorage struct {
+	Client *redis.Client
+}
+
+func NewRedisStorage(client *redis.Client) *RedisStorage {
+	return &RedisStorage{Client: client}
+}
+
+func (r *RedisStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	return get(ctx, r.Client, key)
+}
+
+func (r *RedisStorage) LoadFields(ctx context.Context, key string, fields ...string) (map[string][]byte, error) {
+	result := make(map[string][]byte, len(fields))
+	if len(fields) == 0 {
+		if vals, err := r.Client.HGetAll(ctx, key).Result(); err != nil {
+			return nil, err
+		} else {
+			for field, val := range vals {
+				result[field] = []byte(val)
+			}
+		}
+	} else if vals, err := r.Client.HMGet(ctx, key, fields...).Result(); err != nil {
+		return nil, err
+	} else {
+		for i, val := range vals {
+			if val, ok := val.(string); ok {
+				result[fields[i]] = []byte(val)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (r *RedisStorage) Lookup(ctx context.Context, lookups []*Lookup) error {
+	return lookup(ctx, r.Client, lookups)
+}
+
+func (r *RedisStorage) Score(ctx context.Context, key string, member string) (float64, bool, error) {
+	if score, err := r.Client.ZScore(ctx, key, member).Result(); err == redis.Nil {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	} else {
+		return score, true, nil
+	}
+}
+
+func (r *RedisStorage) RangeByScore(ctx context.Context, key string, sr *ScoreRange) ([]*Member, error) {
+	count := sr.Count
+	if count == 0 && sr.Offset > 0 {
+		count = -1
+	}
+	if items, err := r.Client.ZRangeArgsWithScores(ctx, redis.ZRangeArgs{
+		Key:     key,
+		ByScore: true,
+		Start:   formatScore(sr.Min, sr.MinExclusive),
+		Stop:    formatScore(sr.Max, sr.MaxExclusive),
+		Rev:     sr.Rev,
+		Offset:  sr.Offset,
+		Count:   count,
+	}).Result(); err != nil {
+		return nil, err
+	} else {
+		members := make([]*Member, 0, len(items))
+		for _, item := range items {
+			members = append(members, &Member{
+				Member: item.Member.(string),
+				Score:  item.Score,
+			})
+		}
+		return members, nil
+	}
+}
+
+func (r *RedisStorage) Count(ctx context.Context, key string, sr *ScoreRange) (int64, error) {
+	return r.Client.ZCount(ctx, key, formatScore(sr.Min, sr.MinExclusive), formatScore(sr.Max, sr.MaxExclusive)).Result()
+}
+
+func (r *RedisStorage) Combine(ctx context.Context, dest string, op SetOp, keys ...string) (int64, error) {
+	switch op {
+	case Intersect:
+		return r.Client.ZInterStore(ctx, dest, &redis.ZStore{Keys: keys, Aggregate: "MIN"}).Result()
+	case Union:
+		return r.Client.ZUnionStore(ctx, dest, &redis.ZStore{Keys: keys, Aggregate: "MIN"}).Result()
+	case Difference:
+		return r.Client.ZDiffStore(ctx, dest, keys...).Result()
+	default:
+		return 0, fmt.Errorf("unknown set op %d", op)
+	}
+}
+
+func (r *RedisStorage) Keys(ctx context.Context, prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	iter := r.Client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (r *RedisStorage) AppendStream(ctx context.Context, stream string, id string, values map[string]string) error {
+	return r.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		ID:     id,
+		Values: values,
+	}).Err()
+}
+
+func (r *RedisStorage) ReadStream(ctx context.Context, stream string, sr *StreamRange) ([]*StreamEntry, error) {
+	var msgs []redis.XMessage
+	var err error
+	count := sr.Count
+	if count == 0 {
+		count = -1
+	}
+	if sr.Rev {
+		msgs, err = r.Client.XRevRangeN(ctx, stream, sr.Stop, sr.Start, count).Result()
+	} else {
+		msgs, err = r.Client.XRangeN(ctx, stream, sr.Start, sr.Stop, count).Result()
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*StreamEntry, 0, len(msgs))
+	for _, msg := range msgs {
+		entry := &StreamEntry{
+			Id:     msg.ID,
+			Values: make(map[string]string, len(msg.Values)),
+		}
+		for k, v := range msg.Values {
+			entry.Values[k], _ = v.(string)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (r *RedisStorage) TrimStream(ctx context.Context, stream string, minId string) error {
+	return r.Client.XTrimMinID(ctx, stream, minId).Err()
+}
+
+func (r *RedisStorage) Publish(ctx context.Context, channel string, message string) error {
+	return r.Client.Publish(ctx, channel, message).Err()
+}
+
+func (r *RedisStorage) Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error) {
+	pubsub := r.Client.Subscribe(ctx, channels...)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	messages := make(chan *Message, 100)
+	go func() {
+		defer close(messages)
+		defer pubsub.Close()
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case messages <- &Message{Channel: msg.Channel, Payload: msg.Payload}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return messages, nil
+}
+
+func (r *RedisStorage) Write(ctx context.Context, fn func(w Writer) error) error {
+	_, err := r.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return fn(&redisWriter{ctx: ctx, pipe: pipe})
+	})
+	return err
+}
+
+// Update watches every key read through the Reader, so the writes are
+// discarded and fn retried if any of them change before EXEC.
+func (r *RedisStorage) Update(ctx context.Context, fn func(r Reader, w Writer) error) error {
+	for {
+		err := r.Client.Watch(ctx, func(tx *redis.Tx) error {
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return fn(&redisReader{tx: tx}, &redisWriter{ctx: ctx, pipe: pipe})
+			})
+			return err
+		})
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+}
+
+func (r *RedisStorage) Close() error {
+	return r.Client.Close()
+}
+
+type redisReader struct {
+	tx *redis.Tx
+}
+
+func (r *redisReader) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := r.tx.Watch(ctx, key).Err(); err != nil {
+		return nil, err
+	}
+	return get(ctx, r.tx, key)
+}
+
+func (r *redisReader) Lookup(ctx context.Context, lookups []*Lookup) error {
+	if len(lookups) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(lookups))
+	for _, l := range lookups {
+		keys = append(keys, l.Key)
+	}
+	if err := r.tx.Watch(ctx, keys...).Err(); err != nil {
+		return err
+	}
+	return lookup(ctx, r.tx, lookups)
+}
+
+type redisWriter struct {
+	ctx  context.Context
+	pipe redis.Pipeliner
+}
+
+func (w *redisWriter) Set(key string, value []byte) {
+	w.pipe.Set(w.ctx, key, value, 0)
+}
+
+func (w *redisWriter) Delete(key string) {
+	w.pipe.Del(w.ctx, key)
+}
+
+func (w *redisWriter) SaveFields(key string, fields map[string][]byte) {
+	if len(fields) == 0 {
+		return
+	}
+	values := make(map[string]interface{}, len(fields))
+	for field, value := range fields {
+		values[field] = value
+	}
+	w.pipe.HSet(w.ctx, key, values)
+}
+
+func (w *redisWriter) DeleteFields(key string, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+	w.pipe.HDel(w.ctx, key, fields...)
+}
+
+func (w *redisWriter) AddMember(key string, member string, score float64) {
+	w.pipe.ZAdd(w.ctx, key, redis.Z{
+		Score:  score,
+		Member: member,
+	})
+}
+
+func (w *redisWriter) RemoveMember(key string, member string) {
+	w.pipe.ZRem(w.ctx, key, member)
+}
+
+func (w *redisWriter) RemoveRange(key string, sr *ScoreRange) {
+	w.pipe.ZRemRangeByScore(w.ctx, key, formatScore(sr.Min, sr.MinExclusive), formatScore(sr.Max, sr.MaxExclusive))
+}
+
+func get(ctx context.Context, c redis.Cmdable, key string) ([]byte, error) {
+	if value, err := c.Get(ctx, key).Bytes(); err == redis.Nil {
+		return nil, nil
+	} else {
+		return value, err
+	}
+}
+
+func lookup(ctx context.Context, c redis.Cmdable, lookups []*Lookup) error {
+	cmds := make([]redis.Cmder, len(lookups))
+	if _, err := c.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, l := range lookups {
+			if l.Member {
+				cmds[i] = pipe.ZScore(ctx, l.Key, l.Field)
+			} else {
+				cmds[i] = pipe.HGet(ctx, l.Key, l.Field)
+			}
+		}
+		return nil
+	}); err != nil && err != redis.Nil {
+		return err
+	}
+	for i, cmd := range cmds {
+		l := lookups[i]
+		switch cmd := cmd.(type) {
+		case *redis.FloatCmd:
+			if score, err := cmd.Result(); err == nil {
+				l.Exists = true
+				l.Score = score
+			} else if err != redis.Nil {
+				return err
+			}
+		case *redis.StringCmd:
+			if value, err := cmd.Bytes(); err == nil {
+				l.Exists = true
+				l.Value = value
+			} else if err != redis.Nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func formatScore(score float64, exclusive bool) string {
+	var s string
+	switch {
+	case math.IsInf(score, 1):
+		s = "+inf"
+	case math.IsInf(score, -1):
+		s = "-inf"
+	default:
+		s = strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	if exclusive {
+		return "(" + s
+	}
+	return s
+}
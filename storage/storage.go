@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Storage is the key layout used by the indexer: hashes of fields, sorted sets
+// of scored members, plain values and append-only streams. Keys and members
+// are shared between implementations so data written through one can be read
+// with the same key helpers from db.
+type Storage interface {
+	Reader
+	// LoadFields returns the requested fields of the hash at key, or every
+	// field if none are given. Missing fields are omitted.
+	LoadFields(ctx context.Context, key string, fields ...string) (map[string][]byte, error)
+	Score(ctx context.Context, key string, member string) (score float64, exists bool, err error)
+	RangeByScore(ctx context.Context, key string, r *ScoreRange) ([]*Member, error)
+	// Count returns the number of members whose score falls within r,
+	// ignoring its Offset and Count.
+	Count(ctx context.Context, key string, r *ScoreRange) (int64, error)
+	// Combine replaces dest with the intersection, union or difference of
+	// the sorted sets at keys and returns its size.
+	Combine(ctx context.Context, dest string, op SetOp, keys ...string) (int64, error)
+	Keys(ctx context.Context, prefix string) ([]string, error)
+	AppendStream(ctx context.Context, stream string, id string, values map[string]string) error
+	ReadStream(ctx context.Context, stream string, r *StreamRange) ([]*StreamEntry, error)
+	// TrimStream removes the entries of stream with ids lower than minId.
+	TrimStream(ctx context.Context, stream string, minId string) error
+	// Publish sends message to the current subscribers of channel.
+	Publish(ctx context.Context, channel string, message string) error
+	// Subscribe delivers the messages published to channels until ctx is
+	// done, then closes the returned channel.
+	Subscribe(ctx context.Context, channels ...string) (<-chan *Message, error)
+	// Write applies every change made through the Writer atomically.
+	Write(ctx context.Context, fn func(w Writer) error) error
+	// Update is Write for changes which depend on the current data. Values
+	// read through the Reader are guaranteed to be unchanged when the writes
+	// are applied; if another writer changes them first, fn is called again.
+	Update(ctx context.Context, fn func(r Reader, w Writer) error) error
+	Close() error
+}
+
+// Reader is the part of Storage available to Update.
+type Reader interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Lookup resolves a batch of hash fields and sorted-set members in a
+	// single round trip, filling in Exists, Value and Score.
+	Lookup(ctx context.Context, lookups []*Lookup) error
+}
+
+type Writer interface {
+	Set(key string, value []byte)
+	Delete(key string)
+	SaveFields(key string, fields map[string][]byte)
+	DeleteFields(key string, fields ...string)
+	AddMember(key string, member string, score float64)
+	RemoveMember(key string, member string)
+	RemoveRange(key string, r *ScoreRange)
+}
+
+// SetOp combines sorted sets. Members of an intersection or union keep their
+// lowest score; a difference keeps the members of the first set, and their
+// scores, that are in none of the others.
+type SetOp int
+
+const (
+	Intersect SetOp = iota
+	Union
+	Difference
+)
+
+func combineSets(op SetOp, sets []map[string]float64) map[string]float64 {
+	result := make(map[string]float64)
+	if len(sets) == 0 {
+		return result
+	}
+	switch op {
+	case Intersect:
+		for member, score := range sets[0] {
+			in := true
+			for _, set := range sets[1:] {
+				if other, ok := set[member]; !ok {
+					in = false
+					break
+				} else {
+					score = math.Min(score, other)
+				}
+			}
+			if in {
+				result[member] = score
+			}
+		}
+	case Union:
+		for _, set := range sets {
+			for member, score := range set {
+				if prev, ok := result[member]; !ok || score < prev {
+					result[member] = score
+				}
+			}
+		}
+	case Difference:
+		for member, score := range sets[0] {
+			in := false
+			for _, set := range sets[1:] {
+				if _, in = set[member]; in {
+					break
+				}
+			}
+			if !in {
+				result[member] = score
+			}
+		}
+	}
+	return result
+}
+
+type Lookup struct {
+	Key    string
+	Field  string
+	Member bool
+	Exists bool
+	Value  []byte
+	Score  float64
+}
+
+type Member struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// ScoreRange selects sorted-set members by score. Min and Max are inclusive
+// unless the matching Exclusive flag is set; Count of 0 returns every match.
+type ScoreRange struct {
+	Min          float64
+	Max          float64
+	MinExclusive bool
+	MaxExclusive bool
+	Offset       int64
+	Count        int64
+	Rev          bool
+}
+
+func AllScores() *ScoreRange {
+	return &ScoreRange{
+		Min: math.Inf(-1),
+		Max: math.Inf(1),
+	}
+}
+
+func (r *ScoreRange) Contains(score float64) bool {
+	return (score > r.Min || (!r.MinExclusive && score == r.Min)) &&
+		(score < r.Max || (!r.MaxExclusive && score == r.Max))
+}
+
+// StreamRange selects stream entries by id. Start and Stop accept "-" and "+"
+// for the ends of the stream and a "(" prefix for an exclusive bound.
+type StreamRange struct {
+	Start string
+	Stop  string
+	Count int64
+	Rev   bool
+}
+
+type StreamEntry struct {
+	Id     string
+	Values map[string]string
+}
+
+var opened = map[string]Storage{}
+var openedMu sync.Mutex
+
+// Open connects to the storage described by url. bolt://<path> opens an
+// embedded on-disk store and memory:// a fresh in-memory one; anything else
+// is parsed as a Redis URL. Opening the same bolt path twice returns the same
+// Storage.
+func Open(url string) (Storage, error) {
+	if url == "memory://" {
+		return NewMemoryStorage(), nil
+	} else if path, ok := strings.CutPrefix(url, "bolt://"); ok {
+		openedMu.Lock()
+		defer openedMu.Unlock()
+		if s, ok := opened[path]; ok {
+			return s, nil
+		} else if s, err := OpenBolt(path); err != nil {
+			return nil, err
+		} else {
+			opened[path] = s
+			return s, nil
+		}
+	} else if opt, err := redis.ParseURL(url); err != nil {
+		return nil, err
+	} else {
+		return NewRedisStorage(redis.NewClient(opt)), nil
+	}
+}
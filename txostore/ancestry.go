@@ -0,0 +1,139 @@
+package txostore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+const DEFAULT_ANCESTRY_DEPTH = 100
+
+// AncestryReport lists the ancestors found while ingesting a transaction.
+// Known ancestors were already in the store and were not walked further;
+// Truncated ancestors lay beyond the depth limit and were not ingested.
+type AncestryReport struct {
+	Ingested  []string `json:"ingested"`
+	Known     []string `json:"known"`
+	Truncated []string `json:"truncated"`
+}
+
+type ancestor struct {
+	tx       *transaction.Transaction
+	txid     string
+	depth    int
+	expanded bool
+}
+
+// IngestAncestry walks the SourceTransactions attached to the inputs of tx,
+// without recursion, and ingests every ancestor not already in the store,
+// parents before children, followed by tx itself. Unindexed ancestors needed
+// by a types.AncestorIndexer are loaded from the Source when not attached.
+// Ancestors more than maxDepth generations back are skipped; a maxDepth of 0
+// uses DEFAULT_ANCESTRY_DEPTH.
+func (s *Store) IngestAncestry(ctx context.Context, tx *transaction.Transaction, maxDepth int) (*types.IndexContext, *AncestryReport, error) {
+	if maxDepth <= 0 {
+		maxDepth = DEFAULT_ANCESTRY_DEPTH
+	}
+	report := &AncestryReport{}
+	visited := make(map[string]struct{})
+	truncated := make(map[string]struct{})
+	order := make([]*ancestor, 0)
+	stack := []*ancestor{{tx: tx, txid: tx.TxID()}}
+	for len(stack) > 0 {
+		a := stack[len(stack)-1]
+		if a.expanded {
+			stack = stack[:len(stack)-1]
+			order = append(order, a)
+			continue
+		} else if _, ok := visited[a.txid]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		visited[a.txid] = struct{}{}
+		if a.depth > 0 {
+			if _, known, err := s.txoDb().Score(ctx, db.TxStatusKey, a.txid); err != nil {
+				return nil, nil, err
+			} else if known {
+				report.Known = append(report.Known, a.txid)
+				stack = stack[:len(stack)-1]
+				continue
+			}
+		}
+		a.expanded = true
+		for _, input := range a.tx.Inputs {
+			if input.SourceTransaction == nil && a.depth < maxDepth && !a.tx.IsCoinbase() {
+				if err := s.loadAncestor(ctx, input); err != nil {
+					return nil, nil, err
+				}
+			}
+			if input.SourceTransaction == nil {
+				continue
+			}
+			parent := &ancestor{
+				tx:    input.SourceTransaction,
+				txid:  input.SourceTransaction.TxID(),
+				depth: a.depth + 1,
+			}
+			if _, ok := visited[parent.txid]; ok {
+				continue
+			} else if parent.depth > maxDepth {
+				if _, ok := truncated[parent.txid]; !ok {
+					truncated[parent.txid] = struct{}{}
+					report.Truncated = append(report.Truncated, parent.txid)
+				}
+				continue
+			}
+			stack = append(stack, parent)
+		}
+	}
+
+	var idxCtx *types.IndexContext
+	for _, a := range order {
+		var err error
+		if idxCtx, err = s.ingest(ctx, a.tx); err != nil {
+			return nil, report, err
+		} else if a.depth > 0 {
+			report.Ingested = append(report.Ingested, a.txid)
+		}
+	}
+	return idxCtx, report, nil
+}
+
+// loadAncestor attaches the SourceTransaction of input, with its proof, if
+// it was never indexed and an AncestorIndexer needs the output it spends.
+func (s *Store) loadAncestor(ctx context.Context, input *transaction.TransactionInput) error {
+	indexers := make([]types.AncestorIndexer, 0)
+	for _, indexer := range s.Indexers {
+		if a, ok := indexer.(types.AncestorIndexer); ok {
+			indexers = append(indexers, a)
+		}
+	}
+	if len(indexers) == 0 {
+		return nil
+	}
+	txid := hex.EncodeToString(input.SourceTXID)
+	if _, known, err := s.txoDb().Score(ctx, db.TxStatusKey, txid); err != nil || known {
+		return err
+	}
+	parent, err := s.source().LoadTx(ctx, txid)
+	if err != nil {
+		return err
+	} else if int(input.SourceTxOutIndex) >= len(parent.Outputs) {
+		return nil
+	}
+	output := parent.Outputs[input.SourceTxOutIndex]
+	for _, indexer := range indexers {
+		if indexer.NeedsAncestor(output) {
+			if parent.MerklePath, err = s.source().LoadProof(ctx, txid); err != nil && !errors.Is(err, db.ErrInvalidProof) {
+				return err
+			}
+			input.SourceTransaction = parent
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package txostore
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/shruggr/casemod-indexer/mod/ord"
+)
+
+func TestIngestAncestryLoadsOrigins(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		depth    int
+		ingested int
+		nonce    uint32
+	}{
+		{"whole ancestry", 0, 2, 2},
+		{"beyond the depth", 1, 1, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestChain(t, &ord.OriginIndexer{})
+			funding := c.coinbase(1000)
+			c.mine(funding, 100)
+			c.ingest(funding)
+			// Neither ancestor of transfer is ingested or attached
+			minted := c.spend(funding, []uint32{0}, 1, 999)
+			moved := c.spend(minted, []uint32{0}, 1)
+			transfer := c.spend(moved, []uint32{0}, 1)
+
+			_, report, err := c.store.IngestAncestry(ctx, transfer, tt.depth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{moved.TxID()}
+			if tt.ingested == 2 {
+				want = []string{minted.TxID(), moved.TxID()}
+			}
+			if !slices.Equal(report.Ingested, want) {
+				t.Fatalf("ingested %v, want %v", report.Ingested, want)
+			}
+
+			txo := c.txo(transfer, 0)
+			if tt.nonce == 0 {
+				if txo != nil {
+					t.Fatalf("origin %+v beyond the depth", txo.Data[ord.ORIGIN_TAG])
+				}
+				return
+			} else if txo == nil || txo.Data[ord.ORIGIN_TAG] == nil {
+				t.Fatal("no origin")
+			}
+			origin := txo.Data[ord.ORIGIN_TAG].Obj.(*ord.Origin)
+			if origin.Outpoint.Txid.String() != minted.TxID() || origin.Outpoint.Vout != 0 {
+				t.Fatalf("origin %s", origin.Outpoint.String())
+			} else if origin.Nonce != tt.nonce {
+				t.Fatalf("nonce %d, want %d", origin.Nonce, tt.nonce)
+			}
+		})
+	}
+}
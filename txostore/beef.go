@@ -0,0 +1,120 @@
+package txostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/bitcoin-sv/go-sdk/util"
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+var ErrInvalidBEEF = errors.New("invalid beef")
+
+var AtomicBEEFPrefix = []byte{0x01, 0x01, 0x01, 0x01}
+
+// BEEFPrefix is the little-endian BEEF version, 0xEFBE0001.
+var BEEFPrefix = []byte{0x01, 0x00, 0xbe, 0xef}
+
+// ParseBEEF parses a BEEF or Atomic BEEF bundle and returns its subject
+// transaction with the ancestors in the bundle attached to its inputs.
+func ParseBEEF(beef []byte) (tx *transaction.Transaction, err error) {
+	var subject []byte
+	if bytes.HasPrefix(beef, AtomicBEEFPrefix) {
+		if len(beef) < 36 {
+			return nil, fmt.Errorf("%w: truncated atomic beef", ErrInvalidBEEF)
+		}
+		subject = util.ReverseBytes(beef[4:36])
+		beef = beef[36:]
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx = nil
+			err = fmt.Errorf("%w: %v", ErrInvalidBEEF, r)
+		}
+	}()
+	if tx, err = transaction.NewTransactionFromBEEF(beef); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidBEEF, err)
+	} else if subject != nil && !bytes.Equal(tx.TxIDBytes(), subject) {
+		return nil, fmt.Errorf("%w: subject %x is not the last transaction", ErrInvalidBEEF, subject)
+	}
+	return tx, nil
+}
+
+// ParseTx parses a raw transaction or, if it carries a BEEF prefix, a BEEF
+// bundle.
+func ParseTx(b []byte) (*transaction.Transaction, error) {
+	if bytes.HasPrefix(b, AtomicBEEFPrefix) || bytes.HasPrefix(b, BEEFPrefix) {
+		return ParseBEEF(b)
+	}
+	return transaction.NewTransactionFromBytes(b)
+}
+
+// IngestBEEF verifies the merkle paths in a BEEF bundle against the stored
+// block headers, saves every transaction and proof in it, and ingests the
+// unknown ones parents first. It returns the txos of the subject transaction.
+func (s *Store) IngestBEEF(ctx context.Context, beef []byte) ([]*types.Txo, error) {
+	tx, err := ParseBEEF(beef)
+	if err != nil {
+		return nil, err
+	}
+	if idxCtx, err := s.ingestBundle(ctx, tx); err != nil {
+		return nil, err
+	} else {
+		return idxCtx.Txos, nil
+	}
+}
+
+// ingestBundle verifies and saves tx and the ancestors attached to its
+// inputs, then ingests those not already in the store.
+func (s *Store) ingestBundle(ctx context.Context, tx *transaction.Transaction) (*types.IndexContext, error) {
+	txs, err := s.verifyBundle(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	return s.saveBundle(ctx, tx, txs)
+}
+
+// verifyBundle checks the proofs of tx and the ancestors attached to its
+// inputs, returning every transaction of the bundle.
+func (s *Store) verifyBundle(ctx context.Context, tx *transaction.Transaction) ([]*transaction.Transaction, error) {
+	txs := make([]*transaction.Transaction, 0)
+	seen := map[string]struct{}{}
+	queue := []*transaction.Transaction{tx}
+	for len(queue) > 0 {
+		t := queue[0]
+		queue = queue[1:]
+		txid := t.TxID()
+		if _, ok := seen[txid]; ok {
+			continue
+		}
+		seen[txid] = struct{}{}
+		txs = append(txs, t)
+		if t.MerklePath != nil {
+			if _, err := db.VerifyProof(ctx, s.headers(), txid, t.MerklePath); errors.Is(err, db.ErrInvalidProof) || errors.Is(err, db.ErrHeaderNotFound) {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidBEEF, err)
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		for _, input := range t.Inputs {
+			if input.SourceTransaction != nil {
+				queue = append(queue, input.SourceTransaction)
+			}
+		}
+	}
+	return txs, nil
+}
+
+// saveBundle saves the verified txs of the bundle of tx and ingests those
+// not already in the store.
+func (s *Store) saveBundle(ctx context.Context, tx *transaction.Transaction, txs []*transaction.Transaction) (*types.IndexContext, error) {
+	for _, t := range txs {
+		s.source().SaveTx(ctx, t)
+	}
+	idxCtx, _, err := s.IngestAncestry(ctx, tx, len(txs))
+	return idxCtx, err
+}
@@ -0,0 +1,25 @@
+package txostore
+
+import (
+	"context"
+	"log"
+
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+// Broadcast verifies the proofs of tx and any ancestors attached to its
+// inputs, then submits tx through b. Only once b accepts it are tx and its
+// ancestors saved and ingested, so a rejected transaction leaves nothing
+// behind. If b fails the *transaction.BroadcastFailure is returned.
+func (s *Store) Broadcast(ctx context.Context, tx *transaction.Transaction, b transaction.Broadcaster) (*types.IndexContext, error) {
+	txs, err := s.verifyBundle(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if _, failure := b.Broadcast(tx); failure != nil {
+		log.Println("Broadcast", tx.TxID(), failure.Code, failure.Description)
+		return nil, failure
+	}
+	return s.saveBundle(ctx, tx, txs)
+}
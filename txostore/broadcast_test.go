@@ -0,0 +1,75 @@
+package txostore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/bitcoin-sv/go-sdk/transaction/broadcaster"
+	"github.com/shruggr/casemod-indexer/db"
+)
+
+// arcStub answers every ARC /tx request with status.
+func arcStub(t *testing.T, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tx" {
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(&broadcaster.ArcResponse{Status: status, Title: http.StatusText(status)})
+	}))
+}
+
+func TestBroadcast(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		status  int
+		known   bool
+		indexed bool
+	}{
+		{"accepted", http.StatusOK, false, true},
+		{"rejected", 460, false, false},
+		{"rejected known tx", 460, true, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestChain(t)
+			parent := c.coinbase(1000)
+			c.mine(parent, 100)
+			c.ingest(parent)
+			ancestor := c.spend(parent, []uint32{0}, 1000)
+			child := c.spend(ancestor, []uint32{0}, 1000)
+			child.Inputs[0].SourceTransaction = ancestor
+			if tt.known {
+				c.ingest(child)
+			}
+			// Only the mined parent is saved before the broadcast
+			source := db.NewMemorySource(parent)
+			c.store.Source = source
+			server := arcStub(t, tt.status)
+			defer server.Close()
+
+			_, err := c.store.Broadcast(ctx, child, &broadcaster.Arc{ApiUrl: server.URL})
+			var failure *transaction.BroadcastFailure
+			if failed := errors.As(err, &failure); failed != (tt.status != http.StatusOK) {
+				t.Fatalf("broadcast err %v", err)
+			} else if indexed := c.txo(child, 0) != nil; indexed != tt.indexed {
+				t.Fatalf("txo indexed %v, want %v", indexed, tt.indexed)
+			} else if indexed := c.status(child) != 0; indexed != tt.indexed {
+				t.Fatalf("tx status indexed %v", indexed)
+			} else if indexed := c.status(ancestor) != 0; indexed != tt.indexed {
+				t.Fatalf("ancestor status indexed %v", indexed)
+			} else if spent := c.txo(parent, 0).Spend != nil; spent != tt.indexed {
+				t.Fatalf("parent spent %v", spent)
+			}
+			for _, tx := range []*transaction.Transaction{ancestor, child} {
+				if _, err := source.LoadTx(ctx, tx.TxID()); (err == nil) != (tt.status == http.StatusOK) {
+					t.Fatalf("%s saved %v", tx.TxID(), err == nil)
+				}
+			}
+		})
+	}
+}
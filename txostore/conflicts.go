@@ -0,0 +1,116 @@
+package txostore
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Conflict lists the transactions seen spending an outpoint. Spend is the
+// one currently recorded as spending it.
+type Conflict struct {
+	Outpoint   *types.Outpoint      `json:"outpoint"`
+	Spend      string               `json:"spend,omitempty"`
+	Candidates []*ConflictCandidate `json:"candidates"`
+}
+
+// ConflictCandidate is a spender of a conflicted outpoint. Block is nil while
+// it is unmined and Indexed is false once it has been evicted or reverted.
+type ConflictCandidate struct {
+	Txid    string       `json:"txid"`
+	Block   *types.Block `json:"block"`
+	Indexed bool         `json:"indexed"`
+}
+
+// Conflicts returns the spenders of outpoint. Candidates is empty if only one
+// transaction has been seen spending it.
+func (s *Store) Conflicts(ctx context.Context, outpoint *types.Outpoint) (*Conflict, error) {
+	conflict := &Conflict{
+		Outpoint:   outpoint,
+		Candidates: make([]*ConflictCandidate, 0),
+	}
+	if spends, err := s.loadSpends(ctx, []*types.Txo{{Outpoint: outpoint}}); err != nil {
+		return nil, err
+	} else if spends[0] != nil {
+		conflict.Spend = hex.EncodeToString(spends[0].Txid)
+	}
+	members, err := s.txoDb().RangeByScore(ctx, db.ConflictKey(outpoint), storage.AllScores())
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range members {
+		candidate := &ConflictCandidate{Txid: m.Member}
+		if score, exists, err := s.txoDb().Score(ctx, db.TxStatusKey, m.Member); err != nil {
+			return nil, err
+		} else if exists {
+			candidate.Indexed = true
+			candidate.Block = types.ParseBlockScore(score)
+		}
+		conflict.Candidates = append(conflict.Candidates, candidate)
+	}
+	return conflict, nil
+}
+
+// loadSpends reads the spend recorded on each txo in a single round trip. The
+// result is nil for txos which are unspent.
+func (s *Store) loadSpends(ctx context.Context, txos []*types.Txo) ([]*types.Spend, error) {
+	lookups := make([]*storage.Lookup, 0, len(txos))
+	for _, txo := range txos {
+		lookups = append(lookups, &storage.Lookup{
+			Key:   db.TxoKey(txo.Outpoint),
+			Field: db.SpendMember,
+		})
+	}
+	if err := s.txoDb().Lookup(ctx, lookups); err != nil {
+		return nil, err
+	}
+	spends := make([]*types.Spend, len(txos))
+	for i, l := range lookups {
+		if l.Exists && len(l.Value) > 0 {
+			if err := msgpack.Unmarshal(l.Value, &spends[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return spends, nil
+}
+
+// supersedes reports whether spend should replace the recorded spend of an
+// outpoint: a mined spend replaces any other, while an unmined one never
+// replaces the spend seen first.
+func supersedes(spend *types.Spend) bool {
+	return types.ParseBlockScore(types.BlockScore(spend.Block)) != nil
+}
+
+// resolveConflicts evicts the unmined transactions competing with a mined
+// one for its inputs.
+func (s *Store) resolveConflicts(ctx context.Context, idxCtx *types.IndexContext) error {
+	if types.ParseBlockScore(types.BlockScore(idxCtx.Block)) == nil {
+		return nil
+	}
+	txid := hex.EncodeToString(idxCtx.Txid)
+	for _, spend := range idxCtx.Spends {
+		members, err := s.txoDb().RangeByScore(ctx, db.ConflictKey(spend.Outpoint), storage.AllScores())
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			if m.Member == txid {
+				continue
+			} else if score, exists, err := s.txoDb().Score(ctx, db.TxStatusKey, m.Member); err != nil {
+				return err
+			} else if exists && score > MEMPOOL_SCORE {
+				log.Println("Conflict", spend.Outpoint.String(), "resolved for", txid)
+				if _, err := s.Evict(ctx, m.Member); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package txostore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+func TestConflicts(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		secondMined bool
+		wantSecond  bool
+	}{
+		{"unmined keeps first seen", false, false},
+		{"mined supersedes unmined", true, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestChain(t)
+			parent := c.coinbase(1000)
+			c.mine(parent, 100)
+			c.ingest(parent)
+			first := c.spend(parent, []uint32{0}, 900)
+			c.ingest(first)
+			second := c.spend(parent, []uint32{0}, 800)
+			if tt.secondMined {
+				c.mine(second, 101)
+			}
+			c.ingest(second)
+
+			winner, loser := first, second
+			if tt.wantSecond {
+				winner, loser = second, first
+			}
+			conflict, err := c.store.Conflicts(ctx, &types.Outpoint{Txid: parent.TxIDBytes(), Vout: 0})
+			if err != nil {
+				t.Fatal(err)
+			} else if conflict.Spend != winner.TxID() {
+				t.Fatalf("spend %s, want %s", conflict.Spend, winner.TxID())
+			} else if len(conflict.Candidates) != 2 {
+				t.Fatalf("%d candidates", len(conflict.Candidates))
+			}
+			indexed := make(map[string]bool)
+			for _, candidate := range conflict.Candidates {
+				indexed[candidate.Txid] = candidate.Indexed
+			}
+			if !indexed[winner.TxID()] {
+				t.Fatal("winner not indexed")
+			} else if indexed[loser.TxID()] == tt.secondMined {
+				t.Fatalf("loser indexed %v", indexed[loser.TxID()])
+			}
+			if evicted := c.txo(loser, 0) == nil; evicted != tt.secondMined {
+				t.Fatalf("loser evicted %v", evicted)
+			}
+		})
+	}
+}
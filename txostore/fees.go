@@ -0,0 +1,101 @@
+package txostore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"slices"
+
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/types"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// PersistFees records the fee data of each types.FeeIndexer for a mined
+// transaction, under its position in the block, for the coinbase to pick up.
+func (s *Store) PersistFees(ctx context.Context, idxCtx *types.IndexContext, journal *Journal) error {
+	if idxCtx.Tx.IsCoinbase() || types.ParseBlockScore(types.BlockScore(idxCtx.Block)) == nil {
+		return nil
+	}
+	for _, indexer := range s.Indexers {
+		if f, ok := indexer.(types.FeeIndexer); !ok {
+			continue
+		} else if data := f.Fee(idxCtx); data == nil || data.Obj == nil {
+			continue
+		} else if fee, err := msgpack.Marshal(data.Obj); err != nil {
+			return err
+		} else {
+			journal.HSet(db.FeeKey(indexer.Tag(), idxCtx.Block.Height), db.FeeMember(idxCtx.Block.Idx), fee)
+		}
+	}
+	return nil
+}
+
+// loadFees sets the fees recorded for the block of a mined coinbase, in block
+// order.
+func (s *Store) loadFees(ctx context.Context, idxCtx *types.IndexContext) error {
+	if !idxCtx.Tx.IsCoinbase() || types.ParseBlockScore(types.BlockScore(idxCtx.Block)) == nil {
+		return nil
+	}
+	idxCtx.Fees = make(map[string][]*types.IndexData)
+	for _, indexer := range s.Indexers {
+		if _, ok := indexer.(types.FeeIndexer); !ok {
+			continue
+		}
+		fields, err := s.txoDb().LoadFields(ctx, db.FeeKey(indexer.Tag(), idxCtx.Block.Height))
+		if err != nil {
+			return err
+		}
+		members := make([]string, 0, len(fields))
+		for member := range fields {
+			members = append(members, member)
+		}
+		slices.Sort(members)
+		fees := make([]*types.IndexData, 0, len(members))
+		for _, member := range members {
+			if obj, err := indexer.UnmarshalData(fields[member]); err != nil {
+				return err
+			} else {
+				fees = append(fees, &types.IndexData{Data: fields[member], Obj: obj})
+			}
+		}
+		idxCtx.Fees[indexer.Tag()] = fees
+	}
+	return nil
+}
+
+// settleCoinbase ingests the coinbase spent by input again if the output it
+// spends is missing the data of a types.FeeIndexer, so the fees recorded since
+// it was ingested reach its outputs. It reports whether it was ingested again.
+func (s *Store) settleCoinbase(ctx context.Context, input *transaction.TransactionInput, spend *types.Txo) (bool, error) {
+	if !input.SourceTransaction.IsCoinbase() {
+		return false, nil
+	}
+	missing := false
+	for _, indexer := range s.Indexers {
+		if _, ok := indexer.(types.FeeIndexer); ok && (spend == nil || spend.Data[indexer.Tag()] == nil) {
+			missing = true
+		}
+	}
+	if !missing {
+		return false, nil
+	}
+	txid := hex.EncodeToString(input.SourceTXID)
+	if _, known, err := s.txoDb().Score(ctx, db.TxStatusKey, txid); err != nil || !known {
+		return false, err
+	}
+	coinbase := input.SourceTransaction
+	if coinbase.MerklePath == nil {
+		var err error
+		if coinbase.MerklePath, err = s.source().LoadProof(ctx, txid); err != nil && !errors.Is(err, db.ErrInvalidProof) {
+			return false, err
+		}
+	}
+	if coinbase.MerklePath == nil {
+		return false, nil
+	} else if _, err := s.ingest(ctx, coinbase); err != nil {
+		return false, err
+	}
+	return true, nil
+}
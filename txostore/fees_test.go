@@ -0,0 +1,55 @@
+package txostore
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shruggr/casemod-indexer/mod/ord"
+	modp2pkh "github.com/shruggr/casemod-indexer/mod/p2pkh"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+func TestCoinbaseFees(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		coinbaseFirst bool
+	}{
+		{"coinbase ingested first", true},
+		{"coinbase ingested last", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChain(t, &ord.SatIndexer{}, &modp2pkh.P2pkhIndexer{})
+			prev := c.coinbase(ord.Subsidy(99))
+			c.mine(prev, 99)
+			c.ingest(prev)
+
+			coinbase := c.coinbase(ord.Subsidy(100) + 1000)
+			payer := c.spend(prev, []uint32{0}, ord.Subsidy(99)-1000)
+			c.mineBlock(100, coinbase, payer)
+			if tt.coinbaseFirst {
+				c.ingest(coinbase)
+				if c.txo(coinbase, 0).Data[ord.SAT_TAG] != nil {
+					t.Fatal("coinbase numbered before its fees were ingested")
+				}
+				c.ingest(payer)
+			} else {
+				c.ingest(payer)
+				c.ingest(coinbase)
+			}
+
+			spender := c.spend(coinbase, []uint32{0}, ord.Subsidy(100)+1000)
+			c.ingest(spender)
+			want := []*ord.SatRange{
+				{Start: ord.FirstOrdinal(100), Size: ord.Subsidy(100)},
+				{Start: ord.FirstOrdinal(99) + ord.Subsidy(99) - 1000, Size: 1000},
+			}
+			for _, txo := range []*types.Txo{c.txo(coinbase, 0), c.txo(spender, 0)} {
+				if data := txo.Data[ord.SAT_TAG]; data == nil {
+					t.Fatalf("%s not numbered", txo.Outpoint.String())
+				} else if ranges := data.Obj.(*ord.Sats).Ranges; !reflect.DeepEqual(ranges, want) {
+					t.Fatalf("%s ranges %v", txo.Outpoint.String(), ranges)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package txostore
+
+import (
+	"context"
+	"math"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+type Balance struct {
+	Confirmed   uint64 `json:"confirmed"`
+	Unconfirmed uint64 `json:"unconfirmed"`
+	Utxos       int    `json:"utxos"`
+}
+
+// unspent selects the members of an event key whose txo has not been spent.
+func unspent() *storage.ScoreRange {
+	return &storage.ScoreRange{
+		Min: 0,
+		Max: math.Inf(1),
+	}
+}
+
+// Utxos pages through the unspent txos indexed under the tag's event, oldest
+// first. Only the Cursor, Limit and Fields of params are used.
+func (s *Store) Utxos(ctx context.Context, tag string, e *types.EventLog, params *OwnerTxoParams) (*TxoPage, error) {
+	key := db.EventKey(tag, e)
+	r := unspent()
+	total, err := s.txoDb().Count(ctx, key, r)
+	if err != nil {
+		return nil, err
+	}
+	if params.Cursor != "" {
+		if r.Min, err = decodeCursor(params.Cursor); err != nil {
+			return nil, err
+		}
+		r.MinExclusive = true
+	}
+	members, more, err := s.scorePage(ctx, key, *r, pageSize(params.Limit), nil)
+	if err != nil {
+		return nil, err
+	}
+	page := &TxoPage{Total: total}
+	if more && len(members) > 0 {
+		page.Cursor = encodeCursor(members[len(members)-1].Score)
+	}
+	if page.Txos, err = s.loadMembers(ctx, members, params.Fields); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Balance totals the satoshis of the unspent txos indexed under the tag's
+// event, split by whether the creating transaction has been mined.
+func (s *Store) Balance(ctx context.Context, tag string, e *types.EventLog) (*Balance, error) {
+	members, err := s.txoDb().RangeByScore(ctx, db.EventKey(tag, e), unspent())
+	if err != nil {
+		return nil, err
+	}
+	lookups := make([]*storage.Lookup, 0, len(members))
+	for _, m := range members {
+		lookups = append(lookups, &storage.Lookup{
+			Key:   db.TxoPrefix + m.Member,
+			Field: db.OutputMember,
+		})
+	}
+	if err := s.txoDb().Lookup(ctx, lookups); err != nil {
+		return nil, err
+	}
+	balance := &Balance{}
+	for i, l := range lookups {
+		if l.Exists && len(l.Value) > 0 {
+			balance.Utxos++
+			if satoshis := types.NewOutputFromBytes(l.Value).Satoshis; types.ParseBlockScore(members[i].Score) == nil {
+				balance.Unconfirmed += satoshis
+			} else {
+				balance.Confirmed += satoshis
+			}
+		}
+	}
+	return balance, nil
+}
@@ -0,0 +1,59 @@
+package txostore
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	modp2pkh "github.com/shruggr/casemod-indexer/mod/p2pkh"
+)
+
+func TestBalance(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	parent := c.coinbase(1000)
+	c.mine(parent, 100)
+	c.ingest(parent)
+	other := c.coinbase(500)
+	c.mine(other, 101)
+	c.ingest(other)
+	child := c.spend(parent, []uint32{0}, 600, 300)
+	c.ingest(child)
+
+	balance, err := c.store.Balance(ctx, modp2pkh.TAG, modp2pkh.AddressEvent(testAddress))
+	if err != nil {
+		t.Fatal(err)
+	} else if balance.Confirmed != 500 || balance.Unconfirmed != 900 || balance.Utxos != 3 {
+		t.Fatalf("balance %+v", balance)
+	}
+}
+
+func TestUtxos(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	for height := uint32(100); height < 105; height++ {
+		tx := c.coinbase(uint64(height))
+		c.mine(tx, height)
+		c.ingest(tx)
+	}
+
+	seen := make([]uint64, 0, 5)
+	params := &OwnerTxoParams{Limit: 2}
+	for {
+		page, err := c.store.Utxos(ctx, modp2pkh.TAG, modp2pkh.AddressEvent(testAddress), params)
+		if err != nil {
+			t.Fatal(err)
+		} else if page.Total != 5 || len(page.Txos) > 2 {
+			t.Fatalf("total %d, %d txos", page.Total, len(page.Txos))
+		}
+		for _, txo := range page.Txos {
+			seen = append(seen, txo.Output.Satoshis)
+		}
+		if params.Cursor = page.Cursor; params.Cursor == "" {
+			break
+		}
+	}
+	if !slices.Equal(seen, []uint64{100, 101, 102, 103, 104}) {
+		t.Fatalf("utxos %v", seen)
+	}
+}
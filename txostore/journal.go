@@ -0,0 +1,251 @@
+package txostore
+
+import (
+	"context"
+	"log"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// JournalEntry is the value a hash field or sorted-set member held before a
+// transaction was ingested.
+type JournalEntry struct {
+	Key    string  `msgpack:"k"`
+	Field  string  `msgpack:"f"`
+	Member bool    `msgpack:"m"`
+	Exists bool    `msgpack:"e"`
+	Value  []byte  `msgpack:"v,omitempty"`
+	Score  float64 `msgpack:"s,omitempty"`
+}
+
+type publication struct {
+	channel string
+	message string
+}
+
+type journalWrite struct {
+	key    string
+	field  string
+	member bool
+	value  []byte
+	score  float64
+}
+
+// Journal collects the writes made while ingesting a transaction along with
+// the prior value of everything they touch, so the ingest can be reverted.
+type Journal struct {
+	Txid    string
+	Entries []*JournalEntry
+	writes  []*journalWrite
+	pubs    []*publication
+	// undo holds the values read by LoadPrior, and prior the journal they
+	// were read alongside, so Undo can reverse this ingest alone.
+	undo  []*JournalEntry
+	prior []byte
+}
+
+func NewJournal(txid string) *Journal {
+	return &Journal{
+		Txid: txid,
+	}
+}
+
+func (j *Journal) HSet(key string, field string, value []byte) {
+	j.writes = append(j.writes, &journalWrite{
+		key:   key,
+		field: field,
+		value: value,
+	})
+}
+
+func (j *Journal) ZAdd(key string, score float64, member string) {
+	j.writes = append(j.writes, &journalWrite{
+		key:    key,
+		field:  member,
+		member: true,
+		score:  score,
+	})
+}
+
+// Publish queues message for channel. Queued messages are sent by Notify
+// once the journal has been written.
+func (j *Journal) Publish(channel string, message string) {
+	j.pubs = append(j.pubs, &publication{
+		channel: channel,
+		message: message,
+	})
+}
+
+// Notify sends each distinct queued message through st.
+func (j *Journal) Notify(ctx context.Context, st storage.Storage) error {
+	seen := make(map[publication]struct{}, len(j.pubs))
+	for _, p := range j.pubs {
+		if _, ok := seen[*p]; ok {
+			continue
+		}
+		seen[*p] = struct{}{}
+		if err := st.Publish(ctx, p.channel, p.message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadPrior reads the current value of every field and member the journal
+// will write. If the transaction was ingested before, the entries of the
+// existing journal are kept so a revert still restores the original state.
+// It should be called through Storage.Update, with Write, so no other writer
+// can change the priors before they are overwritten.
+func (j *Journal) LoadPrior(ctx context.Context, st storage.Reader) (err error) {
+	j.Entries = nil
+	if j.prior, err = st.Get(ctx, db.JournalKey(j.Txid)); err != nil {
+		return err
+	} else if j.prior != nil {
+		if err := msgpack.Unmarshal(j.prior, &j.Entries); err != nil {
+			return err
+		}
+	}
+	journaled := make(map[string]struct{}, len(j.Entries))
+	for _, e := range j.Entries {
+		journaled[entryId(e.Key, e.Field, e.Member)] = struct{}{}
+	}
+
+	seen := make(map[string]struct{}, len(j.writes))
+	lookups := make([]*storage.Lookup, 0, len(j.writes))
+	for _, w := range j.writes {
+		id := entryId(w.key, w.field, w.member)
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		lookups = append(lookups, &storage.Lookup{
+			Key:    w.key,
+			Field:  w.field,
+			Member: w.member,
+		})
+	}
+	if err := st.Lookup(ctx, lookups); err != nil {
+		return err
+	}
+	j.undo = make([]*JournalEntry, 0, len(lookups))
+	for _, l := range lookups {
+		e := &JournalEntry{
+			Key:    l.Key,
+			Field:  l.Field,
+			Member: l.Member,
+			Exists: l.Exists,
+			Value:  l.Value,
+			Score:  l.Score,
+		}
+		j.undo = append(j.undo, e)
+		if _, ok := journaled[entryId(e.Key, e.Field, e.Member)]; !ok {
+			j.Entries = append(j.Entries, e)
+		}
+	}
+	return nil
+}
+
+// Write stores the journal and applies its writes through w, so the journal
+// and the data it describes are committed together.
+func (j *Journal) Write(w storage.Writer) error {
+	if data, err := msgpack.Marshal(j.Entries); err != nil {
+		return err
+	} else {
+		w.Set(db.JournalKey(j.Txid), data)
+	}
+	for _, jw := range j.writes {
+		if jw.member {
+			w.AddMember(jw.key, jw.field, jw.score)
+		} else {
+			w.SaveFields(jw.key, map[string][]byte{jw.field: jw.value})
+		}
+	}
+	return nil
+}
+
+// Undo restores everything Write changed, including the journal itself, to
+// the values read by LoadPrior.
+func (j *Journal) Undo(w storage.Writer) error {
+	for i := len(j.undo) - 1; i >= 0; i-- {
+		restore(w, j.undo[i])
+	}
+	if j.prior == nil {
+		w.Delete(db.JournalKey(j.Txid))
+	} else {
+		w.Set(db.JournalKey(j.Txid), j.prior)
+	}
+	return nil
+}
+
+func LoadJournal(ctx context.Context, st storage.Reader, txid string) (entries []*JournalEntry, err error) {
+	if data, err := st.Get(ctx, db.JournalKey(txid)); err != nil {
+		return nil, err
+	} else if data == nil {
+		return nil, nil
+	} else if err := msgpack.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Revert restores every hash field and sorted-set member written while
+// ingesting txid to the value recorded in its journal, in reverse order.
+// Transactions which spend the outputs of txid should be reverted first.
+// Transactions ingested before journaling fall back to Rollback. Every Sink is
+// reverted afterwards.
+func (s *Store) Revert(ctx context.Context, txid string) error {
+	if err := s.revert(ctx, txid); err != nil {
+		return err
+	}
+	for _, sink := range s.Sinks {
+		if err := sink.Revert(ctx, txid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) revert(ctx context.Context, txid string) error {
+	journaled := true
+	if err := s.txoDb().Update(ctx, func(r storage.Reader, w storage.Writer) error {
+		entries, err := LoadJournal(ctx, r, txid)
+		if err != nil {
+			return err
+		} else if journaled = entries != nil; !journaled {
+			return nil
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			restore(w, entries[i])
+		}
+		w.Delete(db.JournalKey(txid))
+		return nil
+	}); err != nil {
+		return err
+	} else if !journaled {
+		log.Println("No journal for", txid)
+		return s.Rollback(ctx, txid)
+	}
+	return nil
+}
+
+func restore(w storage.Writer, e *JournalEntry) {
+	switch {
+	case e.Member && e.Exists:
+		w.AddMember(e.Key, e.Field, e.Score)
+	case e.Member:
+		w.RemoveMember(e.Key, e.Field)
+	case e.Exists:
+		w.SaveFields(e.Key, map[string][]byte{e.Field: e.Value})
+	default:
+		w.DeleteFields(e.Key, e.Field)
+	}
+}
+
+func entryId(key string, field string, member bool) string {
+	if member {
+		return "z:" + key + ":" + field
+	}
+	return "h:" + key + ":" + field
+}
@@ -0,0 +1,246 @@
+package txostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+const DEFAULT_MEMPOOL_WINDOW = 24 * time.Hour
+
+type MempoolReport struct {
+	Promoted []string `json:"promoted"`
+	Evicted  []string `json:"evicted"`
+}
+
+// Mempool returns the transactions ingested as unmined, scored by the unix
+// time they were ingested, oldest first.
+func (s *Store) Mempool(ctx context.Context) ([]*storage.Member, error) {
+	return s.txoDb().RangeByScore(ctx, db.TxStatusKey, &storage.ScoreRange{
+		Min:          MEMPOOL_SCORE,
+		Max:          math.Inf(1),
+		MinExclusive: true,
+	})
+}
+
+// SyncMempool revisits every unmined transaction. Those with a proof are
+// promoted to their block, those with an input spent by another transaction
+// are evicted, as are those ingested more than window ago. A window of 0 uses
+// DEFAULT_MEMPOOL_WINDOW.
+func (s *Store) SyncMempool(ctx context.Context, window time.Duration) (*MempoolReport, error) {
+	if window <= 0 {
+		window = DEFAULT_MEMPOOL_WINDOW
+	}
+	members, err := s.Mempool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	expiry := float64(time.Now().Add(-window).Unix())
+	report := &MempoolReport{}
+	evicted := make(map[string]struct{})
+	for _, m := range members {
+		txid := m.Member
+		if _, ok := evicted[txid]; ok {
+			continue
+		}
+		if promoted, err := s.Promote(ctx, txid); err != nil {
+			return nil, err
+		} else if promoted {
+			report.Promoted = append(report.Promoted, txid)
+			continue
+		}
+		conflicted, err := s.doubleSpent(ctx, txid)
+		if err != nil {
+			return nil, err
+		} else if !conflicted && m.Score >= expiry {
+			continue
+		}
+		if txids, err := s.Evict(ctx, txid); err != nil {
+			return nil, err
+		} else {
+			for _, evictedTxid := range txids {
+				evicted[evictedTxid] = struct{}{}
+			}
+			report.Evicted = append(report.Evicted, txids...)
+		}
+	}
+	return report, nil
+}
+
+// Promote ingests an unmined transaction again once its proof is available,
+// moving its txos, spends and event members to the mined score. Sinks see
+// the promotion with idxCtx.Reingest set. It reports whether the tx was
+// promoted.
+func (s *Store) Promote(ctx context.Context, txid string) (bool, error) {
+	proof, err := s.source().LoadProof(ctx, txid)
+	if errors.Is(err, db.ErrInvalidProof) {
+		log.Println("Promote", txid, err)
+		return false, nil
+	} else if err != nil || proof == nil {
+		return false, err
+	}
+	// Leave the tx in the mempool, with its original timestamp, until the
+	// block header has been synced
+	if _, err := db.VerifyProof(ctx, s.headers(), txid, proof); errors.Is(err, db.ErrHeaderNotFound) || errors.Is(err, db.ErrInvalidProof) {
+		log.Println("Promote", txid, err)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	tx, err := s.source().LoadTx(ctx, txid)
+	if err != nil {
+		return false, err
+	}
+	tx.MerklePath = proof
+	if _, err := s.Ingest(ctx, tx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Evict reverts an unmined transaction along with the unmined transactions
+// spending its indexed outputs, descendants first, and returns the reverted
+// txids in that order. Transactions which spent the same inputs are ingested
+// again afterwards so their spends survive the revert.
+func (s *Store) Evict(ctx context.Context, txid string) ([]string, error) {
+	visited := make(map[string]struct{})
+	order, err := s.mempoolDescendants(ctx, txid, visited)
+	if err != nil {
+		return nil, err
+	}
+
+	winners := make([]string, 0)
+	for _, evicted := range order {
+		if spenders, err := s.conflicts(ctx, evicted); err != nil {
+			return nil, err
+		} else {
+			for _, spender := range spenders {
+				if _, ok := visited[spender]; !ok {
+					winners = append(winners, spender)
+				}
+			}
+		}
+		log.Println("Evict", evicted)
+		if err := s.Revert(ctx, evicted); err != nil {
+			return nil, err
+		}
+	}
+	for _, winner := range winners {
+		if tx, err := s.source().LoadTx(ctx, winner); err != nil {
+			return nil, err
+		} else if tx.MerklePath, err = s.source().LoadProof(ctx, winner); err != nil && !errors.Is(err, db.ErrInvalidProof) {
+			return nil, err
+		} else if _, err := s.Ingest(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// mempoolDescendants returns txid and the unmined transactions descending from
+// it, descendants first. Transactions already in visited are skipped, and
+// every transaction returned is added to it.
+func (s *Store) mempoolDescendants(ctx context.Context, txid string, visited map[string]struct{}) ([]string, error) {
+	order := make([]string, 0)
+	visited[txid] = struct{}{}
+	stack := []*ancestor{{txid: txid}}
+	for len(stack) > 0 {
+		a := stack[len(stack)-1]
+		if a.expanded {
+			stack = stack[:len(stack)-1]
+			order = append(order, a.txid)
+			continue
+		}
+		a.expanded = true
+		children, err := s.mempoolChildren(ctx, a.txid)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if _, ok := visited[child]; !ok {
+				visited[child] = struct{}{}
+				stack = append(stack, &ancestor{txid: child})
+			}
+		}
+	}
+	return order, nil
+}
+
+// mempoolChildren returns the unmined transactions spending the outputs of
+// txid, whether or not those outputs were indexed.
+func (s *Store) mempoolChildren(ctx context.Context, txid string) ([]string, error) {
+	tx, err := s.source().LoadTx(ctx, txid)
+	if err != nil {
+		return nil, err
+	}
+	txos := make([]*types.Txo, 0, len(tx.Outputs))
+	for vout := range tx.Outputs {
+		txos = append(txos, &types.Txo{Outpoint: &types.Outpoint{
+			Txid: tx.TxIDBytes(),
+			Vout: uint32(vout),
+		}})
+	}
+	spends, err := s.loadSpends(ctx, txos)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]string, 0)
+	for _, spend := range spends {
+		if spend == nil {
+			continue
+		}
+		spender := hex.EncodeToString(spend.Txid)
+		if slices.Contains(children, spender) {
+			continue
+		} else if score, exists, err := s.txoDb().Score(ctx, db.TxStatusKey, spender); err != nil {
+			return nil, err
+		} else if exists && score > MEMPOOL_SCORE {
+			children = append(children, spender)
+		}
+	}
+	return children, nil
+}
+
+// conflicts returns the other transactions recorded as spending the inputs
+// of txid.
+func (s *Store) conflicts(ctx context.Context, txid string) ([]string, error) {
+	tx, err := s.source().LoadTx(ctx, txid)
+	if err != nil {
+		return nil, err
+	}
+	spenders := make([]string, 0)
+	if tx.IsCoinbase() {
+		return spenders, nil
+	}
+	txos := make([]*types.Txo, 0, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		txos = append(txos, &types.Txo{Outpoint: &types.Outpoint{
+			Txid: input.SourceTXID,
+			Vout: input.SourceTxOutIndex,
+		}})
+	}
+	spends, err := s.loadSpends(ctx, txos)
+	if err != nil {
+		return nil, err
+	}
+	for _, spend := range spends {
+		if spend != nil && !bytes.Equal(spend.Txid, tx.TxIDBytes()) {
+			spenders = append(spenders, hex.EncodeToString(spend.Txid))
+		}
+	}
+	return spenders, nil
+}
+
+func (s *Store) doubleSpent(ctx context.Context, txid string) (bool, error) {
+	spenders, err := s.conflicts(ctx, txid)
+	return len(spenders) > 0, err
+}
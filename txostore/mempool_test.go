@@ -0,0 +1,199 @@
+package txostore
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/mod/ord"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+func TestPromote(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		header   bool
+		promoted bool
+	}{
+		{"header synced", true, true},
+		{"header missing", false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestChain(t)
+			parent := c.coinbase(1000)
+			c.mine(parent, 100)
+			c.ingest(parent)
+			tx := c.spend(parent, []uint32{0}, 1000)
+			sink := &recordingSink{}
+			c.store.Sinks = []Sink{sink}
+			c.ingest(tx)
+			if score := c.status(tx); score <= MEMPOOL_SCORE {
+				t.Fatalf("mempool score %f", score)
+			}
+			c.mine(tx, 101)
+			if !tt.header {
+				delete(c.headers, 101)
+			}
+
+			if promoted, err := c.store.Promote(ctx, tx.TxID()); err != nil {
+				t.Fatal(err)
+			} else if promoted != tt.promoted {
+				t.Fatalf("promoted %v", promoted)
+			}
+			if mined := c.status(tx) == 101; mined != tt.promoted {
+				t.Fatalf("txs score %f", c.status(tx))
+			} else if mined := c.ownerScore(tx, 0) == 101; mined != tt.promoted {
+				t.Fatalf("owner score %f", c.ownerScore(tx, 0))
+			} else if mined := c.ownerScore(parent, 0) == -101; mined != tt.promoted {
+				t.Fatalf("spent owner score %f", c.ownerScore(parent, 0))
+			}
+			want := []bool{false}
+			if tt.promoted {
+				want = append(want, true)
+			}
+			if !slices.Equal(sink.reingest, want) {
+				t.Fatalf("sink saw reingest %v, want %v", sink.reingest, want)
+			}
+		})
+	}
+}
+
+// recordingSink records the Reingest flag of each ingest it mirrors.
+type recordingSink struct {
+	reingest []bool
+}
+
+func (r *recordingSink) Ingest(ctx context.Context, idxCtx *types.IndexContext) error {
+	r.reingest = append(r.reingest, idxCtx.Reingest)
+	return nil
+}
+
+func (r *recordingSink) Revert(ctx context.Context, txid string) error {
+	return nil
+}
+
+func TestEvict(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		indexers []types.Indexer
+		indexed  bool
+	}{
+		{"indexed", nil, true},
+		{"unindexed", []types.Indexer{&ord.OriginIndexer{}}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestChain(t, tt.indexers...)
+			base := c.coinbase(1000)
+			c.mine(base, 100)
+			c.ingest(base)
+			parent := c.spend(base, []uint32{0}, 600, 400)
+			c.ingest(parent)
+			left := c.spend(parent, []uint32{0}, 600)
+			c.ingest(left)
+			right := c.spend(parent, []uint32{1}, 400)
+			c.ingest(right)
+			grandchild := c.spend(left, []uint32{0}, 500)
+			c.ingest(grandchild)
+			if indexed := c.txo(parent, 0) != nil; indexed != tt.indexed {
+				t.Fatalf("parent output indexed %v", indexed)
+			}
+
+			evicted, err := c.store.Evict(ctx, parent.TxID())
+			if err != nil {
+				t.Fatal(err)
+			} else if len(evicted) != 4 || evicted[3] != parent.TxID() {
+				t.Fatalf("evicted %v", evicted)
+			} else if slices.Index(evicted, grandchild.TxID()) > slices.Index(evicted, left.TxID()) {
+				t.Fatalf("grandchild evicted after its parent: %v", evicted)
+			}
+			for _, txid := range evicted {
+				if score, exists, err := c.store.txoDb().Score(ctx, db.TxStatusKey, txid); err != nil {
+					t.Fatal(err)
+				} else if exists {
+					t.Fatalf("%s still in txs at %f", txid, score)
+				}
+			}
+			if !tt.indexed {
+				return
+			} else if txo := c.txo(base, 0); txo.Spend != nil {
+				t.Fatal("base still spent")
+			} else if score := c.ownerScore(base, 0); score != 100 {
+				t.Fatalf("base owner score %f", score)
+			}
+		})
+	}
+}
+
+func TestDoubleSpentUnindexed(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t, &ord.OriginIndexer{})
+	parent := c.coinbase(1000)
+	c.mine(parent, 100)
+	c.ingest(parent)
+	first := c.spend(parent, []uint32{0}, 900)
+	c.ingest(first)
+	second := c.spend(parent, []uint32{0}, 800)
+
+	if c.txo(parent, 0) != nil {
+		t.Fatal("parent output indexed")
+	} else if spent, err := c.store.doubleSpent(ctx, second.TxID()); err != nil {
+		t.Fatal(err)
+	} else if !spent {
+		t.Fatal("conflicting spend of an unindexed output not detected")
+	} else if spent, err := c.store.doubleSpent(ctx, first.TxID()); err != nil {
+		t.Fatal(err)
+	} else if spent {
+		t.Fatal("first spend reported as conflicting")
+	}
+}
+
+func TestSyncMempool(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		mine     bool
+		age      time.Duration
+		promoted bool
+		evicted  bool
+	}{
+		{"mined", true, 0, true, false},
+		{"pending", false, 0, false, false},
+		{"expired", false, 2 * time.Hour, false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestChain(t)
+			parent := c.coinbase(1000)
+			c.mine(parent, 100)
+			c.ingest(parent)
+			tx := c.spend(parent, []uint32{0}, 1000)
+			c.ingest(tx)
+			if tt.mine {
+				c.mine(tx, 101)
+			}
+			if tt.age > 0 {
+				seen := float64(time.Now().Add(-tt.age).Unix())
+				if err := c.store.txoDb().Write(ctx, func(w storage.Writer) error {
+					w.AddMember(db.TxStatusKey, tx.TxID(), seen)
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			report, err := c.store.SyncMempool(ctx, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			} else if promoted := slices.Contains(report.Promoted, tx.TxID()); promoted != tt.promoted {
+				t.Fatalf("promoted %v", report.Promoted)
+			} else if evicted := slices.Contains(report.Evicted, tx.TxID()); evicted != tt.evicted {
+				t.Fatalf("evicted %v", report.Evicted)
+			} else if indexed := c.txo(tx, 0) != nil; indexed == tt.evicted {
+				t.Fatalf("txo indexed %v", indexed)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package txostore
+
+import (
+	"context"
+	"encoding/hex"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/mod/ord"
+	"github.com/shruggr/casemod-indexer/mod/ordlock"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+const LISTING_TAG = "list"
+
+// OriginHop is one output an ordinal has passed through. Spend and
+// SpendBlock are empty while it is still there, and Sale reports whether a
+// listing on it was bought rather than cancelled.
+type OriginHop struct {
+	Outpoint   *types.Outpoint  `json:"outpoint"`
+	Nonce      uint32           `json:"nonce"`
+	Owner      *types.PKHash    `json:"owner,omitempty"`
+	Block      *types.Block     `json:"block"`
+	Spend      string           `json:"spend,omitempty"`
+	SpendBlock *types.Block     `json:"spendBlock,omitempty"`
+	Listing    *ordlock.Listing `json:"listing,omitempty"`
+	Sale       bool             `json:"sale"`
+}
+
+// originParams loads the fields of a txo read by originHop.
+var originParams = &LoadTxoParams{
+	Block: true,
+	Spend: true,
+	Obj:   true,
+	Tags:  []string{ord.ORIGIN_TAG, LISTING_TAG},
+}
+
+func originKey(origin *types.Outpoint) string {
+	return db.EventKey(ord.ORIGIN_TAG, &types.EventLog{
+		Label: ord.ORIGIN_TAG,
+		Value: origin.String(),
+	})
+}
+
+// OriginHistory returns every output indexed under origin, in transfer order.
+func (s *Store) OriginHistory(ctx context.Context, origin *types.Outpoint) ([]*OriginHop, error) {
+	members, err := s.txoDb().RangeByScore(ctx, originKey(origin), storage.AllScores())
+	if err != nil {
+		return nil, err
+	}
+	txos, err := s.loadMembers(ctx, members, originParams)
+	if err != nil {
+		return nil, err
+	}
+	hops := make([]*OriginHop, 0, len(txos))
+	for _, txo := range txos {
+		if hop, err := s.originHop(ctx, txo); err != nil {
+			return nil, err
+		} else if hop != nil {
+			hops = append(hops, hop)
+		}
+	}
+	sort.SliceStable(hops, func(i, j int) bool {
+		return hops[i].Nonce < hops[j].Nonce
+	})
+	return hops, nil
+}
+
+// OriginLatest returns the output the ordinal was last transferred to, or nil
+// if the origin is unknown. That is the unspent member of the origin's event
+// set with the highest score or, once every output has been spent, the one
+// spent last, which has the lowest score.
+func (s *Store) OriginLatest(ctx context.Context, origin *types.Outpoint) (*OriginHop, error) {
+	key := originKey(origin)
+	members, err := s.txoDb().RangeByScore(ctx, key, &storage.ScoreRange{
+		Min:   0,
+		Max:   math.Inf(1),
+		Count: 1,
+		Rev:   true,
+	})
+	if err != nil {
+		return nil, err
+	} else if len(members) == 0 {
+		if members, err = s.txoDb().RangeByScore(ctx, key, &storage.ScoreRange{
+			Min:          math.Inf(-1),
+			Max:          0,
+			MaxExclusive: true,
+			Count:        1,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if txos, err := s.loadMembers(ctx, members, originParams); err != nil || len(txos) == 0 {
+		return nil, err
+	} else {
+		return s.originHop(ctx, txos[0])
+	}
+}
+
+func (s *Store) originHop(ctx context.Context, txo *types.Txo) (*OriginHop, error) {
+	data := txo.Data[ord.ORIGIN_TAG]
+	if data == nil {
+		return nil, nil
+	}
+	origin, ok := data.Obj.(*ord.Origin)
+	if !ok {
+		return nil, nil
+	}
+	hop := &OriginHop{
+		Outpoint: txo.Outpoint,
+		Nonce:    origin.Nonce,
+		Owner:    txo.Owner,
+		Block:    txo.Block,
+	}
+	if data := txo.Data[LISTING_TAG]; data != nil {
+		hop.Listing, _ = data.Obj.(*ordlock.Listing)
+	}
+	if txo.Spend != nil {
+		hop.Spend = hex.EncodeToString(txo.Spend.Txid)
+		hop.SpendBlock = types.ParseBlockScore(types.BlockScore(txo.Spend.Block))
+		if hop.Listing != nil {
+			if tx, err := s.source().LoadTx(ctx, hop.Spend); err != nil {
+				return nil, err
+			} else {
+				hop.Sale = hop.Listing.PaidBy(tx)
+			}
+		}
+	}
+	return hop, nil
+}
+
+// SatOrigins returns the distinct origins of the one sat outputs which have
+// held the ordinal sat, oldest first.
+func (s *Store) SatOrigins(ctx context.Context, sat uint64) ([]*types.Outpoint, error) {
+	members, err := s.txoDb().RangeByScore(ctx, db.EventKey(ord.SAT_TAG, &types.EventLog{
+		Label: ord.SAT_TAG,
+		Value: strconv.FormatUint(sat, 10),
+	}), storage.AllScores())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return math.Abs(members[i].Score) < math.Abs(members[j].Score)
+	})
+	txos, err := s.loadMembers(ctx, members, &LoadTxoParams{
+		Obj:  true,
+		Tags: []string{ord.ORIGIN_TAG},
+	})
+	if err != nil {
+		return nil, err
+	}
+	origins := make([]*types.Outpoint, 0)
+	seen := make(map[string]struct{})
+	for _, txo := range txos {
+		if data := txo.Data[ord.ORIGIN_TAG]; data == nil {
+			continue
+		} else if origin, ok := data.Obj.(*ord.Origin); !ok {
+			continue
+		} else if _, ok := seen[origin.Outpoint.String()]; !ok {
+			seen[origin.Outpoint.String()] = struct{}{}
+			origins = append(origins, origin.Outpoint)
+		}
+	}
+	return origins, nil
+}
@@ -0,0 +1,55 @@
+package txostore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shruggr/casemod-indexer/mod/ord"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+func TestOriginLatest(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t, &ord.OriginIndexer{})
+	funding := c.coinbase(1000)
+	c.mine(funding, 100)
+	c.ingest(funding)
+	tip := c.spend(funding, []uint32{0}, 1, 999)
+	c.mine(tip, 101)
+	c.ingest(tip)
+	origin := &types.Outpoint{Txid: tip.TxIDBytes(), Vout: 0}
+
+	for i, tt := range []struct {
+		name  string
+		sats  uint64
+		nonce uint32
+		spent bool
+	}{
+		{"transferred", 1, 1, false},
+		{"transferred again", 1, 2, false},
+		{"burned", 0, 2, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := c.spend(tip, []uint32{0}, tt.sats)
+			c.mine(tx, uint32(102+i))
+			c.ingest(tx)
+			if tt.sats == 1 {
+				tip = tx
+			}
+
+			hop, err := c.store.OriginLatest(ctx, origin)
+			if err != nil {
+				t.Fatal(err)
+			} else if hop == nil || hop.Nonce != tt.nonce {
+				t.Fatalf("latest %+v, want nonce %d", hop, tt.nonce)
+			} else if spent := hop.Spend != ""; spent != tt.spent {
+				t.Fatalf("latest spent %v, want %v", spent, tt.spent)
+			}
+			if hops, err := c.store.OriginHistory(ctx, origin); err != nil {
+				t.Fatal(err)
+			} else if last := hops[len(hops)-1]; last.Outpoint.String() != hop.Outpoint.String() {
+				t.Fatalf("latest %s, history ends at %s", hop.Outpoint.String(), last.Outpoint.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package txostore
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+const DEFAULT_PAGE_SIZE = 100
+
+// MAX_PAGE_SIZE caps the limit a caller may request for one page.
+const MAX_PAGE_SIZE = 1000
+
+const (
+	StatusUnspent = "unspent"
+	StatusSpent   = "spent"
+	StatusAll     = "all"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidStatus = errors.New("invalid status")
+
+// OwnerTxoParams pages through the txos of an owner, or the utxos of an
+// event. Status is only used by OwnerTxos; an empty Status selects unspent
+// txos. When Tags are given only txos with events for at least one of them
+// are returned.
+type OwnerTxoParams struct {
+	Status string         `json:"status"`
+	Tags   []string       `json:"tags"`
+	Cursor string         `json:"cursor"`
+	Limit  int64          `json:"limit"`
+	Fields *LoadTxoParams `json:"fields"`
+}
+
+// TxoPage is one page of results. Cursor is empty on the last page and Total
+// counts every match, not just those on the page.
+type TxoPage struct {
+	Txos   []*types.Txo `json:"txos"`
+	Cursor string       `json:"cursor,omitempty"`
+	Total  int64        `json:"total"`
+}
+
+// Cursors are the score of the last member returned. Pages never split the
+// members sharing a score, so the next page starts just past it.
+func encodeCursor(score float64) string {
+	return base64.RawURLEncoding.EncodeToString(binary.BigEndian.AppendUint64(nil, math.Float64bits(score)))
+}
+
+func decodeCursor(cursor string) (float64, error) {
+	if b, err := base64.RawURLEncoding.DecodeString(cursor); err != nil || len(b) != 8 {
+		return 0, ErrInvalidCursor
+	} else if score := math.Float64frombits(binary.BigEndian.Uint64(b)); math.IsNaN(score) {
+		return 0, ErrInvalidCursor
+	} else {
+		return score, nil
+	}
+}
+
+func statusRange(status string) (*storage.ScoreRange, error) {
+	switch status {
+	case "", StatusUnspent:
+		return unspent(), nil
+	case StatusSpent:
+		return &storage.ScoreRange{
+			Min:          math.Inf(-1),
+			Max:          0,
+			MaxExclusive: true,
+		}, nil
+	case StatusAll:
+		return storage.AllScores(), nil
+	default:
+		return nil, ErrInvalidStatus
+	}
+}
+
+// OwnerTxos pages through the owner's txos in score order: spent txos by
+// most recent spend, then unspent txos oldest first.
+func (s *Store) OwnerTxos(ctx context.Context, owner *types.PKHash, params *OwnerTxoParams) (*TxoPage, error) {
+	key := db.OwnerKey(owner)
+	r, err := statusRange(params.Status)
+	if err != nil {
+		return nil, err
+	}
+	total, err := s.countMembers(ctx, key, r, params.Tags)
+	if err != nil {
+		return nil, err
+	}
+	if params.Cursor != "" {
+		if r.Min, err = decodeCursor(params.Cursor); err != nil {
+			return nil, err
+		}
+		r.MinExclusive = true
+	}
+	members, more, err := s.scorePage(ctx, key, *r, pageSize(params.Limit), params.Tags)
+	if err != nil {
+		return nil, err
+	}
+	page := &TxoPage{Total: total}
+	if more && len(members) > 0 {
+		page.Cursor = encodeCursor(members[len(members)-1].Score)
+	}
+	if page.Txos, err = s.loadMembers(ctx, members, params.Fields); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// OwnerHistory pages through every txo the owner has received or spent, most
+// recent activity first. A txo is placed at its spend if it has been spent
+// and at its creation otherwise.
+func (s *Store) OwnerHistory(ctx context.Context, owner *types.PKHash, params *OwnerTxoParams) (*TxoPage, error) {
+	key := db.OwnerKey(owner)
+	total, err := s.countMembers(ctx, key, storage.AllScores(), params.Tags)
+	if err != nil {
+		return nil, err
+	}
+	received := unspent()
+	received.Rev = true
+	spent, _ := statusRange(StatusSpent)
+	if params.Cursor != "" {
+		if cursor, err := decodeCursor(params.Cursor); err != nil {
+			return nil, err
+		} else {
+			received.Max, received.MaxExclusive = cursor, true
+			spent.Min, spent.MinExclusive = -cursor, true
+		}
+	}
+
+	limit := pageSize(params.Limit)
+	members := make([]*storage.Member, 0, limit)
+	boundary := math.Inf(-1)
+	more := false
+	for _, r := range []*storage.ScoreRange{received, spent} {
+		if page, pageMore, err := s.scorePage(ctx, key, *r, limit, params.Tags); err != nil {
+			return nil, err
+		} else {
+			members = append(members, page...)
+			if pageMore {
+				more = true
+				boundary = math.Max(boundary, math.Abs(page[len(page)-1].Score))
+			}
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return math.Abs(members[i].Score) > math.Abs(members[j].Score)
+	})
+	if int64(len(members)) > limit {
+		boundary = math.Max(boundary, math.Abs(members[limit-1].Score))
+	}
+	for i, m := range members {
+		if math.Abs(m.Score) < boundary {
+			members = members[:i]
+			more = true
+			break
+		}
+	}
+
+	page := &TxoPage{Total: total}
+	if more && len(members) > 0 {
+		page.Cursor = encodeCursor(math.Abs(members[len(members)-1].Score))
+	}
+	if page.Txos, err = s.loadMembers(ctx, members, params.Fields); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func pageSize(limit int64) int64 {
+	if limit <= 0 {
+		return DEFAULT_PAGE_SIZE
+	}
+	return min(limit, MAX_PAGE_SIZE)
+}
+
+// scorePage reads at least limit members of key within r, in range order,
+// that match tags. The members sharing the score of the last one are always
+// included. more reports whether r may hold further members.
+func (s *Store) scorePage(ctx context.Context, key string, r storage.ScoreRange, limit int64, tags []string) (page []*storage.Member, more bool, err error) {
+	page = make([]*storage.Member, 0, limit)
+	for {
+		r.Count = limit
+		members, err := s.txoDb().RangeByScore(ctx, key, &r)
+		if err != nil {
+			return nil, false, err
+		}
+		more = int64(len(members)) == limit
+		if more {
+			last := members[len(members)-1].Score
+			seen := make(map[string]struct{})
+			for _, m := range members {
+				if m.Score == last {
+					seen[m.Member] = struct{}{}
+				}
+			}
+			if ties, err := s.txoDb().RangeByScore(ctx, key, &storage.ScoreRange{Min: last, Max: last}); err != nil {
+				return nil, false, err
+			} else {
+				for _, m := range ties {
+					if _, ok := seen[m.Member]; !ok {
+						members = append(members, m)
+					}
+				}
+			}
+			if r.Rev {
+				r.Max, r.MaxExclusive = last, true
+			} else {
+				r.Min, r.MinExclusive = last, true
+			}
+		}
+		if members, err = s.withTags(ctx, members, tags); err != nil {
+			return nil, false, err
+		}
+		page = append(page, members...)
+		if !more || int64(len(page)) >= limit {
+			return page, more, nil
+		}
+	}
+}
+
+func (s *Store) countMembers(ctx context.Context, key string, r *storage.ScoreRange, tags []string) (int64, error) {
+	if len(tags) == 0 {
+		return s.txoDb().Count(ctx, key, r)
+	}
+	if members, err := s.txoDb().RangeByScore(ctx, key, r); err != nil {
+		return 0, err
+	} else if members, err = s.withTags(ctx, members, tags); err != nil {
+		return 0, err
+	} else {
+		return int64(len(members)), nil
+	}
+}
+
+// withTags keeps the members whose txo has events for at least one of tags.
+func (s *Store) withTags(ctx context.Context, members []*storage.Member, tags []string) ([]*storage.Member, error) {
+	if len(tags) == 0 || len(members) == 0 {
+		return members, nil
+	}
+	lookups := make([]*storage.Lookup, 0, len(members)*len(tags))
+	for _, m := range members {
+		for _, tag := range tags {
+			lookups = append(lookups, &storage.Lookup{
+				Key:   db.TxoPrefix + m.Member,
+				Field: db.EventMember(tag),
+			})
+		}
+	}
+	if err := s.txoDb().Lookup(ctx, lookups); err != nil {
+		return nil, err
+	}
+	matched := make([]*storage.Member, 0, len(members))
+	for i, m := range members {
+		for _, l := range lookups[i*len(tags) : (i+1)*len(tags)] {
+			if l.Exists {
+				matched = append(matched, m)
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+func (s *Store) loadMembers(ctx context.Context, members []*storage.Member, params *LoadTxoParams) ([]*types.Txo, error) {
+	outpoints := make([]*types.Outpoint, 0, len(members))
+	for _, m := range members {
+		if outpoint, err := types.NewOutpointFromString(m.Member); err != nil {
+			return nil, err
+		} else {
+			outpoints = append(outpoints, outpoint)
+		}
+	}
+	loaded, err := s.LoadTxos(ctx, outpoints, params)
+	if err != nil {
+		return nil, err
+	}
+	txos := make([]*types.Txo, 0, len(loaded))
+	for _, txo := range loaded {
+		if txo != nil {
+			txos = append(txos, txo)
+		}
+	}
+	return txos, nil
+}
@@ -0,0 +1,20 @@
+package txostore
+
+import "testing"
+
+func TestPageSize(t *testing.T) {
+	for _, tt := range []struct {
+		limit int64
+		want  int64
+	}{
+		{0, DEFAULT_PAGE_SIZE},
+		{-1, DEFAULT_PAGE_SIZE},
+		{10, 10},
+		{MAX_PAGE_SIZE, MAX_PAGE_SIZE},
+		{MAX_PAGE_SIZE + 1, MAX_PAGE_SIZE},
+	} {
+		if got := pageSize(tt.limit); got != tt.want {
+			t.Errorf("pageSize(%d) = %d, want %d", tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package txostore
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"sort"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Query is a search expression over the event sets. A term with a Tag reads
+// the tag's Id/Value event, scoped to Owner if set; a term with only an Owner
+// reads every txo of the owner. Otherwise the query is the intersection of
+// And or the union of Or. Txos matching any of Not are then removed.
+type Query struct {
+	Tag   string        `json:"tag,omitempty"`
+	Id    string        `json:"id,omitempty"`
+	Value string        `json:"value,omitempty"`
+	Owner *types.PKHash `json:"owner,omitempty"`
+	And   []*Query      `json:"and,omitempty"`
+	Or    []*Query      `json:"or,omitempty"`
+	Not   []*Query      `json:"not,omitempty"`
+}
+
+func (q *Query) key() string {
+	e := &types.EventLog{
+		Label: q.Id,
+		Value: q.Value,
+	}
+	if q.Owner == nil {
+		return db.EventKey(q.Tag, e)
+	} else if q.Tag == "" {
+		return db.OwnerKey(q.Owner)
+	}
+	return db.OwnerEventKey(q.Owner.String(), q.Tag, e)
+}
+
+// resolve returns the sorted set holding the txos matched by q, combining
+// event sets into temporary keys as needed. Every temporary key is appended
+// to temps, including on error, for the caller to delete.
+func (s *Store) resolve(ctx context.Context, q *Query, temps *[]string) (string, error) {
+	var key string
+	if q == nil {
+		return "", ErrInvalidQuery
+	} else if q.Tag != "" || q.Owner != nil {
+		if len(q.And) > 0 || len(q.Or) > 0 {
+			return "", ErrInvalidQuery
+		}
+		key = q.key()
+	} else if len(q.And) > 0 && len(q.Or) > 0 {
+		return "", ErrInvalidQuery
+	} else if len(q.And) > 0 {
+		var err error
+		if key, err = s.combine(ctx, storage.Intersect, q.And, temps); err != nil {
+			return "", err
+		}
+	} else if len(q.Or) > 0 {
+		var err error
+		if key, err = s.combine(ctx, storage.Union, q.Or, temps); err != nil {
+			return "", err
+		}
+	} else {
+		return "", ErrInvalidQuery
+	}
+
+	if len(q.Not) == 0 {
+		return key, nil
+	}
+	keys := []string{key}
+	for _, not := range q.Not {
+		if notKey, err := s.resolve(ctx, not, temps); err != nil {
+			return "", err
+		} else {
+			keys = append(keys, notKey)
+		}
+	}
+	dest := db.TempKey()
+	*temps = append(*temps, dest)
+	if _, err := s.txoDb().Combine(ctx, dest, storage.Difference, keys...); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+func (s *Store) combine(ctx context.Context, op storage.SetOp, queries []*Query, temps *[]string) (string, error) {
+	if len(queries) == 1 {
+		return s.resolve(ctx, queries[0], temps)
+	}
+	keys := make([]string, 0, len(queries))
+	for _, sub := range queries {
+		if key, err := s.resolve(ctx, sub, temps); err != nil {
+			return "", err
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	dest := db.TempKey()
+	*temps = append(*temps, dest)
+	if _, err := s.txoDb().Combine(ctx, dest, op, keys...); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+func (s *Store) dropTemps(ctx context.Context, temps []string) {
+	if len(temps) == 0 {
+		return
+	}
+	if err := s.txoDb().Write(ctx, func(w storage.Writer) error {
+		for _, key := range temps {
+			w.Delete(key)
+		}
+		return nil
+	}); err != nil {
+		log.Println("dropTemps", err)
+	}
+}
+
+// RANK_TERM_LIMIT bounds the txos read for each term ranked by RankTxos,
+// most recent first.
+const RANK_TERM_LIMIT = 10000
+
+// RANK_MAX_TERMS bounds the values ranked by one RankTxos call.
+const RANK_MAX_TERMS = 16
+
+// RankedTxo is a txo found by RankTxos along with the number of terms it
+// matched.
+type RankedTxo struct {
+	Txo     *types.Txo `json:"txo"`
+	Matches int        `json:"matches"`
+}
+
+// RankTxos finds the txos with a tag event of label for any of the first
+// RANK_MAX_TERMS values and returns a page of up to limit of them, those
+// matching the most values first, then the most recent.
+func (s *Store) RankTxos(ctx context.Context, tag string, label string, values []string, limit int, params *LoadTxoParams) ([]*RankedTxo, error) {
+	values = values[:min(len(values), RANK_MAX_TERMS)]
+	matches := make(map[string]int)
+	scores := make(map[string]float64)
+	for _, value := range values {
+		members, err := s.txoDb().RangeByScore(ctx, db.EventKey(tag, &types.EventLog{
+			Label: label,
+			Value: value,
+		}), &storage.ScoreRange{
+			Min:   math.Inf(-1),
+			Max:   math.Inf(1),
+			Count: RANK_TERM_LIMIT,
+			Rev:   true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			matches[m.Member]++
+			scores[m.Member] = max(scores[m.Member], math.Abs(m.Score))
+		}
+	}
+	ranked := make([]*storage.Member, 0, len(matches))
+	for member := range matches {
+		ranked = append(ranked, &storage.Member{Member: member, Score: scores[member]})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if mi, mj := matches[ranked[i].Member], matches[ranked[j].Member]; mi != mj {
+			return mi > mj
+		} else if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Member < ranked[j].Member
+	})
+	if size := int(pageSize(int64(limit))); size < len(ranked) {
+		ranked = ranked[:size]
+	}
+	txos, err := s.loadMembers(ctx, ranked, params)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*RankedTxo, 0, len(txos))
+	for _, txo := range txos {
+		results = append(results, &RankedTxo{
+			Txo:     txo,
+			Matches: matches[txo.Outpoint.String()],
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,185 @@
+package txostore
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/bitcoin-sv/go-sdk/script"
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/mod/ord"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+// inscribe builds a transaction spending vout of parent to a 1 sat text
+// inscription owned by testAddress.
+func (c *testChain) inscribe(parent *transaction.Transaction, vout uint32, text string) *transaction.Transaction {
+	s := &script.Script{}
+	s.AppendOpcodes(script.Op0, script.OpIF)
+	s.AppendPushData([]byte("ord"))
+	s.AppendOpcodes(script.Op1)
+	s.AppendPushData([]byte("text/plain"))
+	s.AppendOpcodes(script.Op0)
+	s.AppendPushData([]byte(text))
+	s.AppendOpcodes(script.OpENDIF)
+	*s = append(*s, *lockScript(c.t)...)
+
+	tx := c.spend(parent, []uint32{vout})
+	tx.AddOutput(&transaction.TransactionOutput{Satoshis: 1, LockingScript: s})
+	c.source.AddTx(tx)
+	return tx
+}
+
+// outpoints lists the outpoints of txos, for comparing search results.
+func outpoints(txos []*types.Txo) []string {
+	ops := make([]string, 0, len(txos))
+	for _, txo := range txos {
+		ops = append(ops, txo.Outpoint.String())
+	}
+	return ops
+}
+
+func TestSearchTxosQuery(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t, &ord.InscriptionIndexer{})
+	funding := c.coinbase(4)
+	c.mine(funding, 100)
+	c.ingest(funding)
+	funding = c.spend(funding, []uint32{0}, 1, 1, 1, 1)
+	c.mine(funding, 101)
+	c.ingest(funding)
+	owner, err := types.NewPKHashFromAddress(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := make([]string, 0, 4)
+	for i, text := range []string{"hello world", "hello there", "goodbye world", "goodbye"} {
+		tx := c.inscribe(funding, uint32(i), text)
+		c.mine(tx, uint32(102+i))
+		c.ingest(tx)
+		ops = append(ops, (&types.Outpoint{Txid: tx.TxIDBytes(), Vout: 0}).String())
+	}
+	word := func(value string) *Query {
+		return &Query{Tag: "insc", Id: "word", Value: value}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		query  *Query
+		desc   bool
+		limit  uint32
+		offset uint32
+		want   []int
+	}{
+		{"single term", word("hello"), false, 0, 0, []int{0, 1}},
+		{"and", &Query{And: []*Query{word("hello"), word("world")}}, false, 0, 0, []int{0}},
+		{"or", &Query{Or: []*Query{word("there"), word("goodbye")}}, false, 0, 0, []int{1, 2, 3}},
+		{"not", &Query{Or: []*Query{word("world")}, Not: []*Query{word("hello")}}, false, 0, 0, []int{2}},
+		{"nested", &Query{And: []*Query{
+			{Or: []*Query{word("there"), word("goodbye")}},
+			{Owner: owner},
+		}, Not: []*Query{word("world")}}, false, 0, 0, []int{1, 3}},
+		{"descending", word("goodbye"), true, 0, 0, []int{3, 2}},
+		{"paged", &Query{Or: []*Query{word("hello"), word("goodbye")}}, true, 2, 1, []int{2, 1}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			txos, err := c.store.SearchTxos(ctx, SearchTxoParams{
+				Query:  tt.query,
+				Desc:   tt.desc,
+				Limit:  tt.limit,
+				Offset: tt.offset,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make([]string, 0, len(tt.want))
+			for _, i := range tt.want {
+				want = append(want, ops[i])
+			}
+			if got := outpoints(txos); !slices.Equal(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	for _, q := range []*Query{
+		{},
+		{And: []*Query{word("hello")}, Or: []*Query{word("world")}},
+		{Tag: "insc", Id: "word", Value: "hello", And: []*Query{word("world")}},
+	} {
+		if _, err := c.store.SearchTxos(ctx, SearchTxoParams{Query: q}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("query %+v: %v", q, err)
+		}
+	}
+}
+
+func TestRankTxos(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t, &ord.InscriptionIndexer{})
+	funding := c.coinbase(3)
+	c.mine(funding, 100)
+	c.ingest(funding)
+	funding = c.spend(funding, []uint32{0}, 1, 1, 1)
+	c.mine(funding, 101)
+	c.ingest(funding)
+	padded := make([]string, 0, RANK_MAX_TERMS+1)
+	for i := 0; i < RANK_MAX_TERMS; i++ {
+		padded = append(padded, "pad"+strconv.Itoa(i))
+	}
+	txs := make([]*transaction.Transaction, 0, 3)
+	for i, text := range []string{"Hello, world!", "hello there", "goodbye world"} {
+		tx := c.inscribe(funding, uint32(i), text)
+		c.mine(tx, uint32(102+i))
+		c.ingest(tx)
+		txs = append(txs, tx)
+	}
+	owner, err := types.NewPKHashFromAddress(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	word := &types.EventLog{Label: "word", Value: "hello"}
+	if n, err := c.store.txoDb().Count(ctx, db.TxoOwnerKey(owner, "insc", word), storage.AllScores()); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Fatalf("word fanned out to %d owner event members", n)
+	} else if n, err := c.store.txoDb().Count(ctx, db.TxoEventKey("insc", word), storage.AllScores()); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Fatalf("%d word event members", n)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		values []string
+		limit  int
+		want   []int
+		counts []int
+	}{
+		{"most matches first", []string{"hello", "world"}, 0, []int{0, 2, 1}, []int{2, 1, 1}},
+		{"limit", []string{"hello", "world"}, 2, []int{0, 2}, []int{2, 1}},
+		{"single term", []string{"there"}, 0, []int{1}, []int{1}},
+		{"no match", []string{"nothing"}, 0, []int{}, []int{}},
+		{"caps the terms", append(padded, "hello"), 0, []int{}, []int{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ranked, err := c.store.RankTxos(ctx, "insc", "word", tt.values, tt.limit, nil)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ranked) != len(tt.want) {
+				t.Fatalf("%d results, want %d", len(ranked), len(tt.want))
+			}
+			for i, r := range ranked {
+				want := &types.Outpoint{Txid: txs[tt.want[i]].TxIDBytes(), Vout: 0}
+				if r.Txo.Outpoint.String() != want.String() {
+					t.Fatalf("result %d is %s, want %s", i, r.Txo.Outpoint.String(), want.String())
+				} else if r.Matches != tt.counts[i] {
+					t.Fatalf("result %d matched %d, want %d", i, r.Matches, tt.counts[i])
+				}
+			}
+		})
+	}
+}
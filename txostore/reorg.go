@@ -0,0 +1,198 @@
+package txostore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"slices"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+// Reorg rolls back every transaction mined at or above forkHeight, newest
+// first, and removes the orphaned block headers. The unmined transactions
+// spending their outputs are rolled back before them. The rolled back txids
+// are returned parents first so they can be re-ingested once the new branch
+// has been synced.
+func (s *Store) Reorg(ctx context.Context, forkHeight uint32) ([]string, error) {
+	members, err := s.txoDb().RangeByScore(ctx, db.TxStatusKey, &storage.ScoreRange{
+		Min:          float64(forkHeight),
+		Max:          MEMPOOL_SCORE,
+		MaxExclusive: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Reorg", forkHeight, "orphaned", len(members), "txns")
+	reverted := make([]string, 0, len(members))
+	visited := make(map[string]struct{}, len(members))
+	for i := len(members) - 1; i >= 0; i-- {
+		order, err := s.mempoolDescendants(ctx, members[i].Member, visited)
+		if err != nil {
+			return nil, err
+		}
+		for _, txid := range order {
+			if err := s.Revert(ctx, txid); err != nil {
+				return nil, err
+			}
+		}
+		reverted = append(reverted, order...)
+	}
+	if err := db.DeleteBlocks(ctx, s.txoDb(), forkHeight); err != nil {
+		return nil, err
+	}
+	slices.Reverse(reverted)
+	return reverted, nil
+}
+
+// Tip returns the height of the highest block header held by the store, or 0
+// if none has been synced.
+func (s *Store) Tip(ctx context.Context) (uint32, error) {
+	blockIds, err := s.txoDb().RangeByScore(ctx, db.BlockIdKey, &storage.ScoreRange{
+		Min:   0,
+		Max:   math.Inf(1),
+		Rev:   true,
+		Count: 1,
+	})
+	if err != nil || len(blockIds) == 0 {
+		return 0, err
+	}
+	return uint32(blockIds[0].Score), nil
+}
+
+// FindFork returns the lowest height at which the headers held by the store
+// have been orphaned, or 0 if they are all on the main chain.
+func (s *Store) FindFork(ctx context.Context, height uint32) (uint32, error) {
+	return db.FindFork(ctx, s.txoDb(), height)
+}
+
+// Reingest drops the cached proofs of the given transactions and ingests them
+// again, picking up their position in the new branch. Transactions which were
+// not mined in the new branch are ingested as mempool transactions.
+func (s *Store) Reingest(ctx context.Context, txids []string) error {
+	for _, txid := range txids {
+		if err := s.source().DeleteProof(ctx, txid); err != nil {
+			return err
+		}
+		tx, err := s.source().LoadTx(ctx, txid)
+		if err != nil {
+			return err
+		}
+		// A proof which fails verification is quarantined and the tx is
+		// ingested as unmined until a valid one is available
+		if tx.MerklePath, err = s.source().LoadProof(ctx, txid); errors.Is(err, db.ErrInvalidProof) {
+			log.Println("Reingest", txid, err)
+		} else if err != nil {
+			return err
+		}
+		if _, err := s.Ingest(ctx, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback removes everything Ingest wrote for txid: the outputs it created,
+// their event and owner index members, and the spend markers it placed on its
+// inputs, whose index members are restored to their unspent scores. It derives
+// the writes from the stored data, for transactions which have no journal.
+func (s *Store) Rollback(ctx context.Context, txid string) error {
+	tx, err := s.source().LoadTx(ctx, txid)
+	if err != nil {
+		return err
+	}
+	txidBytes := tx.TxIDBytes()
+
+	txos := make([]*types.Txo, 0, len(tx.Outputs))
+	for vout := range tx.Outputs {
+		if txo, err := s.LoadTxo(ctx, &types.Outpoint{
+			Txid: txidBytes,
+			Vout: uint32(vout),
+		}, nil); err != nil {
+			return err
+		} else if txo != nil {
+			txos = append(txos, txo)
+		}
+	}
+
+	// The spend marker is written on every input, indexed or not
+	spends := make([]*types.Txo, 0, len(tx.Inputs))
+	if !tx.IsCoinbase() {
+		inputs := make([]*types.Txo, 0, len(tx.Inputs))
+		for _, input := range tx.Inputs {
+			inputs = append(inputs, &types.Txo{Outpoint: &types.Outpoint{
+				Txid: input.SourceTXID,
+				Vout: input.SourceTxOutIndex,
+			}})
+		}
+		markers, err := s.loadSpends(ctx, inputs)
+		if err != nil {
+			return err
+		}
+		for i, marker := range markers {
+			if marker == nil || !bytes.Equal(marker.Txid, txidBytes) {
+				continue
+			} else if spend, err := s.LoadTxo(ctx, inputs[i].Outpoint, nil); err != nil {
+				return err
+			} else if spend != nil {
+				spends = append(spends, spend)
+			} else {
+				spends = append(spends, inputs[i])
+			}
+		}
+	}
+
+	return s.txoDb().Write(ctx, func(w storage.Writer) error {
+		for _, txo := range txos {
+			member := txo.Outpoint.String()
+			fields := make([]string, 0, 2+len(txo.Data)*3)
+			fields = append(fields, db.OutputMember, db.OwnerMember)
+			for tag, data := range txo.Data {
+				fields = append(fields, db.DepMember(tag), db.EventMember(tag), db.DataMember(tag))
+				for _, e := range data.Events {
+					for _, key := range eventKeys(txo, tag, e) {
+						w.RemoveMember(key, member)
+					}
+				}
+			}
+			// Leave any spend written by a descendant in place
+			w.DeleteFields(db.TxoKey(txo.Outpoint), fields...)
+		}
+
+		for _, spend := range spends {
+			member := spend.Outpoint.String()
+			w.DeleteFields(db.TxoKey(spend.Outpoint), db.SpendMember)
+			spend.Spend = nil
+			for tag, data := range spend.Data {
+				indexer := s.IndexerMap()[tag]
+				if indexer == nil {
+					continue
+				}
+				score := indexer.Score(spend)
+				for _, e := range data.Events {
+					for _, key := range eventKeys(spend, tag, e) {
+						w.AddMember(key, member, score)
+					}
+				}
+			}
+		}
+		w.RemoveMember(db.TxStatusKey, hex.EncodeToString(txidBytes))
+		return nil
+	})
+}
+
+func eventKeys(txo *types.Txo, tag string, e *types.EventLog) []string {
+	if txo.Owner == nil || e.Search {
+		return []string{db.TxoEventKey(tag, e)}
+	}
+	return []string{
+		db.TxoEventKey(tag, e),
+		db.OwnerKey(txo.Owner),
+		db.TxoOwnerKey(txo.Owner, tag, e),
+	}
+}
@@ -4,23 +4,67 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"log"
+	"math"
 	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/bitcoin-sv/go-sdk/transaction"
 	"github.com/bitcoin-sv/go-sdk/util"
-	"github.com/redis/go-redis/v9"
 	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
 	"github.com/shruggr/casemod-indexer/types"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+// Sink mirrors the txo store into another database. A transaction ingested
+// again, such as a promotion, reaches Ingest with idxCtx.Reingest set.
+type Sink interface {
+	Ingest(ctx context.Context, idxCtx *types.IndexContext) error
+	Revert(ctx context.Context, txid string) error
+}
+
 type Store struct {
-	Indexers   []types.Indexer
-	indexerMap map[string]types.Indexer
-	tags       []string
+	Indexers []types.Indexer
+	// DB holds the txos, events and journals. Defaults to db.Txos.
+	DB storage.Storage
+	// Source supplies transactions and proofs. Defaults to db.RemoteSource.
+	Source db.TxSource
+	// Headers verifies merkle proofs. Defaults to db.Headers.
+	Headers db.HeaderSource
+	// Sinks receive every ingested and reverted transaction once the txo
+	// store has been updated. If one fails, the ingest is undone and its
+	// error returned.
+	Sinks []Sink
+	// AncestryDepth limits how many generations of attached ancestors Ingest
+	// will walk. Defaults to DEFAULT_ANCESTRY_DEPTH.
+	AncestryDepth int
+	indexerMap    map[string]types.Indexer
+	tags          []string
+}
+
+func (s *Store) txoDb() storage.Storage {
+	if s.DB == nil {
+		return db.Txos
+	}
+	return s.DB
+}
+
+func (s *Store) headers() db.HeaderSource {
+	if s.Headers == nil {
+		return db.Headers{}
+	}
+	return s.Headers
+}
+
+func (s *Store) source() db.TxSource {
+	if s.Source == nil {
+		return db.RemoteSource{}
+	}
+	return s.Source
 }
 
 func (s *Store) IndexerMap() map[string]types.Indexer {
@@ -50,11 +94,16 @@ type LoadTxoParams struct {
 	Events bool     `json:"events"`
 	Obj    bool     `json:"data"`
 	Tags   []string `json:"tags"`
+	// NoScript omits the locking script of each output.
+	NoScript bool `json:"noScript"`
+	// NoContent omits content bytes, such as inscription files, from the
+	// indexed data.
+	NoContent bool `json:"noContent"`
 }
 
 func (l *LoadTxoParams) keys() []string {
 	keys := make([]string, 0, 2+len(l.Tags)*3)
-	keys = append(keys, string(db.OutputMember))
+	keys = append(keys, string(db.OutputMember), db.OwnerMember)
 	if l.Spend {
 		keys = append(keys, string(db.SpendMember))
 	}
@@ -72,15 +121,108 @@ func (l *LoadTxoParams) keys() []string {
 	return keys
 }
 
+// project drops the parts of txo the params ask to omit.
+func (l *LoadTxoParams) project(txo *types.Txo) *types.Txo {
+	if l == nil || txo == nil {
+		return txo
+	}
+	if l.NoScript && txo.Output != nil {
+		txo.Output.Script = nil
+	}
+	if l.NoContent {
+		for _, idxData := range txo.Data {
+			if obj, ok := idxData.Obj.(types.ContentOmitter); ok {
+				obj.OmitContent()
+			}
+		}
+	}
+	return txo
+}
+
+// MEMPOOL_SCORE is the highest score of a mined txo. Unmined transactions
+// score above it, see types.BlockScore.
+const MEMPOOL_SCORE = 0x1FFFFF
+
+// SearchTxoParams selects txos by Query or, if it is not set, by the single
+// Tag/Id/Value event, optionally scoped to Owner.
+//
+// Status picks unspent (the default), spent or all txos. FromHeight and
+// ToHeight bound the height of the creating transaction for unspent txos and
+// of the spending transaction for spent ones. Mempool txos have no height and
+// are included unless ToHeight is set; Mempool returns only them. Results are
+// in chronological order, newest first when Desc is set.
 type SearchTxoParams struct {
-	Tag    string         `json:"tag"`
-	Id     string         `json:"id"`
-	Value  string         `json:"value"`
-	Owner  *types.PKHash  `json:"owner"`
-	Spent  bool           `json:"spent"`
-	Limit  uint32         `json:"limit"`
-	Offset uint32         `json:"cursor"`
-	Fields *LoadTxoParams `json:"fields"`
+	Tag        string         `json:"tag"`
+	Id         string         `json:"id"`
+	Value      string         `json:"value"`
+	Owner      *types.PKHash  `json:"owner"`
+	Query      *Query         `json:"query"`
+	Status     string         `json:"status"`
+	Spent      bool           `json:"spent"`
+	FromHeight uint32         `json:"fromHeight"`
+	ToHeight   uint32         `json:"toHeight"`
+	Mempool    bool           `json:"mempool"`
+	Desc       bool           `json:"desc"`
+	Limit      uint32         `json:"limit"`
+	Offset     uint32         `json:"cursor"`
+	Fields     *LoadTxoParams `json:"fields"`
+}
+
+// scoreRanges returns the score windows selected by the status, height and
+// mempool filters, each ordered chronologically.
+func (p *SearchTxoParams) scoreRanges() ([]*storage.ScoreRange, error) {
+	status := p.Status
+	if status == "" && p.Spent {
+		status = StatusSpent
+	}
+	var unspent, spent bool
+	switch status {
+	case "", StatusUnspent:
+		unspent = true
+	case StatusSpent:
+		spent = true
+	case StatusAll:
+		unspent, spent = true, true
+	default:
+		return nil, ErrInvalidStatus
+	}
+
+	windows := make([]*storage.ScoreRange, 0, 2)
+	if !p.Mempool {
+		mined := &storage.ScoreRange{
+			Min: types.BlockScore(&types.Block{Height: p.FromHeight}),
+			Max: MEMPOOL_SCORE,
+		}
+		if p.ToHeight > 0 {
+			mined.Max = types.BlockScore(&types.Block{Height: p.ToHeight + 1})
+			mined.MaxExclusive = true
+		}
+		windows = append(windows, mined)
+	}
+	if p.Mempool || p.ToHeight == 0 {
+		windows = append(windows, &storage.ScoreRange{
+			Min:          MEMPOOL_SCORE,
+			Max:          math.Inf(1),
+			MinExclusive: true,
+		})
+	}
+
+	ranges := make([]*storage.ScoreRange, 0, 4)
+	for _, w := range windows {
+		if unspent {
+			ranges = append(ranges, w)
+		}
+		if spent {
+			ranges = append(ranges, &storage.ScoreRange{
+				Min:          -w.Max,
+				Max:          -w.Min,
+				MinExclusive: w.MaxExclusive,
+				MaxExclusive: w.MinExclusive || w.Min == 0,
+				Rev:          true,
+			})
+		}
+	}
+	return ranges, nil
 }
 
 type TxoSearchResult struct {
@@ -88,17 +230,30 @@ type TxoSearchResult struct {
 	Cursor uint64       `json:"cursor"`
 }
 
-func (s *Store) LoadTxosByTxid(ctx context.Context, txid string, req *LoadTxoParams) ([]*types.Txo, error) {
-	iter := db.Txos.Scan(ctx, 0, db.TxoTxidKey(txid), 100).Iterator()
-	txos := make([]*types.Txo, 0, 10)
-	for iter.Next(ctx) {
-		if err := iter.Err(); err != nil {
-			return nil, err
-		} else if outpoint, err := types.NewOutpointFromString(iter.Val()[4:]); err != nil {
-			return nil, err
-		} else if txo, err := s.LoadTxo(ctx, outpoint, req); err != nil {
-			return nil, err
-		} else {
+// LoadTxosByTxid loads the indexed txos created by txid, in output order,
+// enumerating them from the stored transaction.
+func (s *Store) LoadTxosByTxid(ctx context.Context, txid string, params *LoadTxoParams) ([]*types.Txo, error) {
+	txos := make([]*types.Txo, 0)
+	if _, exists, err := s.txoDb().Score(ctx, db.TxStatusKey, txid); err != nil || !exists {
+		return txos, err
+	}
+	tx, err := s.source().LoadTx(ctx, txid)
+	if err != nil {
+		return nil, err
+	}
+	outpoints := make([]*types.Outpoint, 0, len(tx.Outputs))
+	for vout := range tx.Outputs {
+		outpoints = append(outpoints, &types.Outpoint{
+			Txid: tx.TxIDBytes(),
+			Vout: uint32(vout),
+		})
+	}
+	loaded, err := s.LoadTxos(ctx, outpoints, params)
+	if err != nil {
+		return nil, err
+	}
+	for _, txo := range loaded {
+		if txo != nil {
 			txos = append(txos, txo)
 		}
 	}
@@ -112,35 +267,92 @@ func (s *Store) LoadTxo(ctx context.Context, outpoint *types.Outpoint, params *L
 	}
 
 	if params == nil || params.Block {
-		txo.Block = db.LoadTxBlock(ctx, outpoint.Txid.String())
+		if txo.Block, err = s.LoadTxBlock(ctx, outpoint.Txid.String()); err != nil {
+			return nil, err
+		}
 	}
 
-	txoMap := make(map[string][]byte)
+	var keys []string
+	if params != nil {
+		keys = params.keys()
+	}
+	txoMap, err := s.txoDb().LoadFields(ctx, db.TxoKey(outpoint), keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	return params.project(s.parseTxo(txo, txoMap)), nil
+}
+
+// LoadTxos loads many txos in a single round trip, reading the requested
+// fields of every txo and the block of every distinct transaction through one
+// Lookup. The result is aligned with outpoints and is nil where a txo has not
+// been indexed. Nil params load every field of every indexer.
+func (s *Store) LoadTxos(ctx context.Context, outpoints []*types.Outpoint, params *LoadTxoParams) ([]*types.Txo, error) {
 	if params == nil {
-		if err := db.Txos.HGetAll(ctx, db.TxoKey(outpoint)).Scan(&txoMap); err == redis.Nil || len(txoMap) == 0 {
-			return nil, nil
-		} else if err != nil {
-			return nil, err
+		params = &LoadTxoParams{
+			Block:  true,
+			Spend:  true,
+			Deps:   true,
+			Events: true,
+			Obj:    true,
+			Tags:   s.Tags(),
 		}
-	} else {
-		keys := params.keys()
-		if len(keys) == 0 {
-			return txo, nil
-		} else if err := db.Txos.HMGet(ctx, db.TxoKey(outpoint), keys...).Scan(&txoMap); err == redis.Nil || len(txoMap) == 0 {
-			return txo, nil
-		} else if err != nil {
-			return nil, err
+	}
+	keys := params.keys()
+	lookups := make([]*storage.Lookup, 0, len(outpoints)*(len(keys)+1))
+	for _, outpoint := range outpoints {
+		txoKey := db.TxoKey(outpoint)
+		for _, key := range keys {
+			lookups = append(lookups, &storage.Lookup{Key: txoKey, Field: key})
+		}
+	}
+	blocks := make(map[string]*storage.Lookup)
+	if params.Block {
+		for _, outpoint := range outpoints {
+			if txid := outpoint.Txid.String(); blocks[txid] == nil {
+				blocks[txid] = &storage.Lookup{Key: db.TxStatusKey, Field: txid, Member: true}
+				lookups = append(lookups, blocks[txid])
+			}
 		}
 	}
+	if err := s.txoDb().Lookup(ctx, lookups); err != nil {
+		return nil, err
+	}
 
+	txos := make([]*types.Txo, len(outpoints))
+	for i, outpoint := range outpoints {
+		txoMap := make(map[string][]byte, len(keys))
+		for _, l := range lookups[i*len(keys) : (i+1)*len(keys)] {
+			if l.Exists {
+				txoMap[l.Field] = l.Value
+			}
+		}
+		txos[i] = params.project(s.parseTxo(&types.Txo{
+			Outpoint: outpoint,
+			Data:     make(map[string]*types.IndexData),
+		}, txoMap))
+		if l := blocks[outpoint.Txid.String()]; txos[i] != nil && l != nil && l.Exists {
+			txos[i].Block = types.ParseBlockScore(l.Score)
+		}
+	}
+	return txos, nil
+}
+
+// parseTxo fills in txo from the fields of its hash, returning nil if it has
+// no output.
+func (s *Store) parseTxo(txo *types.Txo, txoMap map[string][]byte) *types.Txo {
 	if output := txoMap[db.OutputMember]; len(output) == 0 {
-		return nil, nil
+		return nil
 	} else {
 		txo.Output = types.NewOutputFromBytes(output)
 	}
 	for member, data := range txoMap {
 		switch member {
 		case string(db.OutputMember):
+		case db.OwnerMember:
+			owner := types.PKHash(data)
+			txo.Owner = &owner
 		case string(db.SpendMember):
 			if err := msgpack.Unmarshal(data, &txo.Spend); err != nil {
 				log.Panic(err)
@@ -166,6 +378,7 @@ func (s *Store) LoadTxo(ctx context.Context, outpoint *types.Outpoint, params *L
 				idxData.Data = data
 				indexer := s.IndexerMap()[tag]
 				if indexer != nil {
+					var err error
 					if idxData.Obj, err = indexer.UnmarshalData(data); err != nil {
 						log.Panic(err)
 					}
@@ -174,51 +387,77 @@ func (s *Store) LoadTxo(ctx context.Context, outpoint *types.Outpoint, params *L
 		}
 	}
 
-	return txo, nil
+	return txo
 }
 
-func (s *Store) SearchTxos(ctx context.Context, params SearchTxoParams) ([]*types.Txo, error) {
-	var key string
-	if params.Owner == nil {
-		key = db.EventKey(params.Tag, &types.EventLog{
-			Label: params.Id,
-			Value: params.Value,
-		})
+func (s *Store) LoadTxBlock(ctx context.Context, txid string) (*types.Block, error) {
+	if score, exists, err := s.txoDb().Score(ctx, db.TxStatusKey, txid); err != nil || !exists {
+		return nil, err
 	} else {
-		key = db.OwnerEventKey(params.Owner.String(), params.Tag, &types.EventLog{
-			Label: params.Id,
+		return types.ParseBlockScore(score), nil
+	}
+}
+
+func (s *Store) SearchTxos(ctx context.Context, params SearchTxoParams) ([]*types.Txo, error) {
+	query := params.Query
+	if query == nil {
+		query = &Query{
+			Tag:   params.Tag,
+			Id:    params.Id,
 			Value: params.Value,
-		})
+			Owner: params.Owner,
+		}
 	}
-	start := 0.0
-	stop := 1.0
-	if params.Spent {
-		start = 1
-		stop = 2
-	}
-
-	if outpoints, err := db.Txos.ZRangeArgs(ctx, redis.ZRangeArgs{
-		Key:     key,
-		ByScore: true,
-		Start:   start,
-		Stop:    stop,
-		Count:   int64(params.Limit),
-		Offset:  int64(params.Offset),
-	}).Result(); err != nil {
+	var temps []string
+	defer func() {
+		s.dropTemps(ctx, temps)
+	}()
+	key, err := s.resolve(ctx, query, &temps)
+	if err != nil {
 		return nil, err
+	}
+	ranges, err := params.scoreRanges()
+	if err != nil {
+		return nil, err
+	}
+
+	count := int64(0)
+	if params.Limit > 0 {
+		count = int64(params.Offset) + int64(params.Limit)
+	}
+	members := make([]*storage.Member, 0)
+	for _, r := range ranges {
+		r.Count = count
+		r.Rev = r.Rev != params.Desc
+		if page, err := s.txoDb().RangeByScore(ctx, key, r); err != nil {
+			return nil, err
+		} else {
+			members = append(members, page...)
+		}
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		return math.Abs(members[i].Score) < math.Abs(members[j].Score) != params.Desc
+	})
+	if int64(params.Offset) >= int64(len(members)) {
+		members = members[:0]
 	} else {
-		txos := make([]*types.Txo, 0, len(outpoints))
-		for _, op := range outpoints {
-			if outpoint, err := types.NewOutpointFromString(op); err != nil {
-				log.Panicf("Invalid outpoint %s: %s", outpoint, err)
-			} else if txo, err := s.LoadTxo(ctx, outpoint, params.Fields); err != nil {
-				return nil, err
-			} else {
-				txos = append(txos, txo)
-			}
+		members = members[params.Offset:]
+	}
+	if params.Limit > 0 && int(params.Limit) < len(members) {
+		members = members[:params.Limit]
+	}
+
+	txos := make([]*types.Txo, 0, len(members))
+	for _, m := range members {
+		if outpoint, err := types.NewOutpointFromString(m.Member); err != nil {
+			log.Panicf("Invalid outpoint %s: %s", outpoint, err)
+		} else if txo, err := s.LoadTxo(ctx, outpoint, params.Fields); err != nil {
+			return nil, err
+		} else {
+			txos = append(txos, txo)
 		}
-		return txos, nil
 	}
+	return txos, nil
 }
 
 func NewIndexContext(ctx context.Context, tx *transaction.Transaction) *types.IndexContext {
@@ -229,8 +468,8 @@ func NewIndexContext(ctx context.Context, tx *transaction.Transaction) *types.In
 		Height: uint32(time.Now().Unix()),
 	}
 	if tx.MerklePath != nil {
+		// Hash is set by the Store once the proof has been verified
 		block.Height = tx.MerklePath.BlockHeight
-		// TODO: populate block hash
 		idx := slices.IndexFunc(tx.MerklePath.Path[0], func(pe *transaction.PathElement) bool {
 			return bytes.Equal(pe.Hash, util.ReverseBytes(txid))
 		})
@@ -248,9 +487,19 @@ func NewIndexContext(ctx context.Context, tx *transaction.Transaction) *types.In
 	}
 }
 
+// Ingest indexes tx, first ingesting any unknown ancestors attached to its
+// inputs as SourceTransactions, up to AncestryDepth generations back.
 func (s *Store) Ingest(ctx context.Context, tx *transaction.Transaction) (idxCtx *types.IndexContext, err error) {
+	idxCtx, _, err = s.IngestAncestry(ctx, tx, s.AncestryDepth)
+	return
+}
+
+func (s *Store) ingest(ctx context.Context, tx *transaction.Transaction) (idxCtx *types.IndexContext, err error) {
 	idxCtx = NewIndexContext(ctx, tx)
-	if err = s.PopulateInputs(ctx, idxCtx); err != nil {
+	if err = s.VerifyBlock(ctx, idxCtx); err != nil {
+		log.Println("VerifyBlock", err)
+		return nil, err
+	} else if err = s.PopulateInputs(ctx, idxCtx); err != nil {
 		log.Println("PopulateInputs", err)
 		return nil, err
 	} else if err = s.ParseOutputs(ctx, idxCtx); err != nil {
@@ -258,47 +507,127 @@ func (s *Store) Ingest(ctx context.Context, tx *transaction.Transaction) (idxCtx
 		return nil, err
 	}
 
-	if _, err = db.Txos.Pipelined(ctx, func(pipe redis.Pipeliner) error {
-		if err := s.PersistSpends(ctx, idxCtx, pipe); err != nil {
-			log.Println("PersistSpends", err)
-			return err
-		} else if err := s.PersistTxos(ctx, idxCtx, pipe); err != nil {
-			log.Println("PersistTxos", err)
+	txid := hex.EncodeToString(idxCtx.Txid)
+	journal := NewJournal(txid)
+	if err = s.PersistSpends(ctx, idxCtx, journal); err != nil {
+		log.Println("PersistSpends", err)
+		return nil, err
+	} else if err = s.PersistTxos(ctx, idxCtx, journal); err != nil {
+		log.Println("PersistTxos", err)
+		return nil, err
+	} else if err = s.PersistFees(ctx, idxCtx, journal); err != nil {
+		log.Println("PersistFees", err)
+		return nil, err
+	}
+	journal.ZAdd(db.TxStatusKey, types.BlockScore(idxCtx.Block), txid)
+
+	if err = s.txoDb().Update(ctx, func(r storage.Reader, w storage.Writer) error {
+		if err := journal.LoadPrior(ctx, r); err != nil {
+			log.Println("LoadPrior", err)
 			return err
 		}
-
-		return nil
+		return journal.Write(w)
 	}); err != nil {
-		log.Println("Pipelined", err)
+		log.Println("Write", err)
 		return nil, err
 	}
+	idxCtx.Reingest = journal.prior != nil
+	for i, sink := range s.Sinks {
+		if err = sink.Ingest(ctx, idxCtx); err != nil {
+			log.Println("Sink", err)
+			if uerr := s.undo(ctx, journal, s.Sinks[:i]); uerr != nil {
+				log.Println("Undo", txid, uerr)
+				return nil, errors.Join(err, uerr)
+			}
+			return nil, err
+		}
+	}
+	if err := journal.Notify(ctx, s.txoDb()); err != nil {
+		log.Println("Notify", err)
+	}
+	// The ingest is committed by now; conflicts left behind are evicted by
+	// the next SyncMempool
+	if err := s.resolveConflicts(ctx, idxCtx); err != nil {
+		log.Println("ResolveConflicts", txid, err)
+	}
 	return idxCtx, nil
 }
 
+// undo reverses an ingest which a Sink failed to mirror, so the caller can
+// retry it. The sinks which did mirror it are reverted as well, unless the
+// transaction had been ingested before; their rows then stay ahead of the
+// txo store until the retry. An error is returned if the txo store could not
+// be restored, leaving the ingest in place.
+func (s *Store) undo(ctx context.Context, journal *Journal, sinks []Sink) error {
+	if err := s.txoDb().Write(ctx, journal.Undo); err != nil {
+		return err
+	} else if journal.prior != nil {
+		return nil
+	}
+	for _, sink := range sinks {
+		if err := sink.Revert(ctx, journal.Txid); err != nil {
+			log.Println("Sink Revert", journal.Txid, err)
+		}
+	}
+	return nil
+}
+
+// VerifyBlock checks the merkle proof of the transaction against the stored
+// block header and sets the block hash. A proof which does not match is
+// quarantined and rejected with db.ErrInvalidProof; one whose header has not
+// been synced yet is ignored and the transaction is indexed as unmined.
+func (s *Store) VerifyBlock(ctx context.Context, idxCtx *types.IndexContext) error {
+	if idxCtx.Tx.MerklePath == nil {
+		return nil
+	}
+	txid := hex.EncodeToString(idxCtx.Txid)
+	if header, err := db.VerifyProof(ctx, s.headers(), txid, idxCtx.Tx.MerklePath); errors.Is(err, db.ErrHeaderNotFound) {
+		log.Println("VerifyBlock", txid, err)
+		idxCtx.Block = &types.Block{
+			Height: uint32(time.Now().Unix()),
+		}
+	} else if errors.Is(err, db.ErrInvalidProof) {
+		s.source().QuarantineProof(ctx, txid, idxCtx.Tx.MerklePath)
+		return err
+	} else if err != nil {
+		return err
+	} else if idxCtx.Block.Hash, err = hex.DecodeString(header.Hash); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (s *Store) PopulateInputs(ctx context.Context, idxCtx *types.IndexContext) (err error) {
 	if !idxCtx.Tx.IsCoinbase() {
 		for vin, input := range idxCtx.Tx.Inputs {
 			if input.SourceTransaction == nil {
-				if input.SourceTransaction, err = db.LoadTx(ctx, hex.EncodeToString(input.SourceTXID)); err != nil {
+				if input.SourceTransaction, err = s.source().LoadTx(ctx, hex.EncodeToString(input.SourceTXID)); err != nil {
 					return err
 				}
-			} else if _, err = s.Ingest(ctx, input.SourceTransaction); err != nil {
-				return err
 			}
 
 			outpoint := &types.Outpoint{
 				Txid: input.SourceTXID,
 				Vout: input.SourceTxOutIndex,
 			}
-			var spend *types.Txo
-			if spend, err = s.LoadTxo(ctx, outpoint, &LoadTxoParams{
+			params := &LoadTxoParams{
 				Block:  true,
 				Events: true,
 				Obj:    true,
 				Tags:   s.Tags(),
-			}); err != nil {
+			}
+			var spend *types.Txo
+			var settled bool
+			if spend, err = s.LoadTxo(ctx, outpoint, params); err != nil {
+				return err
+			} else if settled, err = s.settleCoinbase(ctx, input, spend); err != nil {
 				return err
-			} else if spend == nil {
+			} else if settled {
+				if spend, err = s.LoadTxo(ctx, outpoint, params); err != nil {
+					return err
+				}
+			}
+			if spend == nil {
 				spend = &types.Txo{
 					Outpoint: outpoint,
 					Output: &types.Output{
@@ -319,6 +648,9 @@ func (s *Store) PopulateInputs(ctx context.Context, idxCtx *types.IndexContext)
 }
 
 func (s *Store) ParseOutputs(ctx context.Context, idxCtx *types.IndexContext) (err error) {
+	if err = s.loadFees(ctx, idxCtx); err != nil {
+		return err
+	}
 	for vout, output := range idxCtx.Tx.Outputs {
 		var txo *types.Txo
 		outpoint := &types.Outpoint{
@@ -329,6 +661,11 @@ func (s *Store) ParseOutputs(ctx context.Context, idxCtx *types.IndexContext) (e
 			Spend: true,
 		}); err != nil {
 			return err
+		} else if txo == nil {
+			txo = &types.Txo{
+				Outpoint: outpoint,
+				Data:     make(map[string]*types.IndexData),
+			}
 		}
 		txo.Output = &types.Output{
 			Satoshis: output.Satoshis,
@@ -349,16 +686,32 @@ func (s *Store) ParseOutputs(ctx context.Context, idxCtx *types.IndexContext) (e
 	return nil
 }
 
-func (s *Store) PersistSpends(ctx context.Context, idxCtx *types.IndexContext, pipe redis.Cmdable) (err error) {
-	for _, spend := range idxCtx.Spends {
+// PersistSpends marks the inputs of the transaction as spent. An input
+// already spent by another transaction is added to its conflict set along
+// with both spenders, and keeps its existing spend unless it is superseded.
+func (s *Store) PersistSpends(ctx context.Context, idxCtx *types.IndexContext, journal *Journal) (err error) {
+	priors, err := s.loadSpends(ctx, idxCtx.Spends)
+	if err != nil {
+		return err
+	}
+	for i, spend := range idxCtx.Spends {
+		if prior := priors[i]; prior != nil && !bytes.Equal(prior.Txid, spend.Spend.Txid) {
+			key := db.ConflictKey(spend.Outpoint)
+			journal.ZAdd(key, types.BlockScore(prior.Block), hex.EncodeToString(prior.Txid))
+			journal.ZAdd(key, types.BlockScore(spend.Spend.Block), hex.EncodeToString(spend.Spend.Txid))
+			if !supersedes(spend.Spend) {
+				log.Println("Conflict", spend.Outpoint.String(), "kept", hex.EncodeToString(prior.Txid))
+				continue
+			}
+		}
 		if s, err := msgpack.Marshal(spend.Spend); err != nil {
 			log.Println(spend.Outpoint.String(), err)
 			return err
-		} else if err := pipe.HSet(ctx, db.TxoKey(spend.Outpoint), db.SpendMember, s).Err(); err != nil {
-			log.Println(spend.Outpoint.String(), err)
-			return err
+		} else {
+			journal.HSet(db.TxoKey(spend.Outpoint), db.SpendMember, s)
 		}
 
+		member := spend.Outpoint.String()
 		for tag, data := range spend.Data {
 			indexer := s.IndexerMap()[tag]
 			if indexer == nil {
@@ -367,29 +720,11 @@ func (s *Store) PersistSpends(ctx context.Context, idxCtx *types.IndexContext, p
 
 			score := indexer.Score(spend)
 			for _, e := range data.Events {
-				member := spend.Outpoint.String()
-				pipe.ZAdd(ctx,
-					db.TxoEventKey(tag, e),
-					redis.Z{
-						Score:  score,
-						Member: member,
-					},
-				)
-				if spend.Owner != nil {
-					pipe.ZAdd(ctx,
-						db.OwnerKey(spend.Owner),
-						redis.Z{
-							Score:  score,
-							Member: member,
-						},
-					)
-					pipe.ZAdd(ctx,
-						db.TxoOwnerKey(spend.Owner, tag, e),
-						redis.Z{
-							Score:  score,
-							Member: member,
-						},
-					)
+				for _, key := range eventKeys(spend, tag, e) {
+					journal.ZAdd(key, score, member)
+					if !e.Search {
+						journal.Publish(key, member)
+					}
 				}
 			}
 		}
@@ -397,10 +732,17 @@ func (s *Store) PersistSpends(ctx context.Context, idxCtx *types.IndexContext, p
 	return nil
 }
 
-func (s *Store) PersistTxos(ctx context.Context, idxCtx *types.IndexContext, pipe redis.Pipeliner) (err error) {
+func (s *Store) PersistTxos(ctx context.Context, idxCtx *types.IndexContext, journal *Journal) (err error) {
 	for _, txo := range idxCtx.Txos {
-		txoData := make(map[string]interface{}, 10)
-		txoData[db.OutputMember] = txo.Output.Bytes()
+		if len(txo.Data) == 0 {
+			continue
+		}
+		txoKey := db.TxoKey(txo.Outpoint)
+		member := txo.Outpoint.String()
+		journal.HSet(txoKey, db.OutputMember, txo.Output.Bytes())
+		if txo.Owner != nil {
+			journal.HSet(txoKey, db.OwnerMember, []byte(*txo.Owner))
+		}
 		for _, indexer := range s.Indexers {
 			tag := indexer.Tag()
 			idxData := txo.Data[tag]
@@ -408,65 +750,35 @@ func (s *Store) PersistTxos(ctx context.Context, idxCtx *types.IndexContext, pip
 				continue
 			}
 			if len(idxData.Deps) > 0 {
-				txoData[db.DepMember(tag)] = idxData.Deps
+				journal.HSet(txoKey, db.DepMember(tag), marshal(txo, idxData.Deps))
 			}
 
 			if idxData.Obj != nil {
-				txoData[db.DataMember(tag)] = idxData.Obj
+				journal.HSet(txoKey, db.DataMember(tag), marshal(txo, idxData.Obj))
 			}
 
 			if len(idxData.Events) > 0 {
-				txoData[db.EventMember(tag)] = idxData.Events
+				journal.HSet(txoKey, db.EventMember(tag), marshal(txo, idxData.Events))
 				score := indexer.Score(txo)
 				for _, e := range idxData.Events {
-					member := txo.Outpoint.String()
-					pipe.ZAdd(ctx,
-						db.TxoEventKey(tag, e),
-						redis.Z{
-							Score:  score,
-							Member: member,
-						},
-					)
-					if txo.Owner != nil {
-						pipe.ZAdd(ctx,
-							db.OwnerKey(txo.Owner),
-							redis.Z{
-								Score:  score,
-								Member: member,
-							},
-						)
-						pipe.ZAdd(ctx,
-							db.TxoOwnerKey(txo.Owner, tag, e),
-							redis.Z{
-								Score:  score,
-								Member: member,
-							},
-						)
+					for _, key := range eventKeys(txo, tag, e) {
+						journal.ZAdd(key, score, member)
+						if !e.Search {
+							journal.Publish(key, member)
+						}
 					}
 				}
 			}
 		}
-		if len(txo.Data) == 0 {
-			continue
-		}
-		txoMap := make(map[string]interface{}, len(txoData))
-		for k, v := range txoData {
-			switch k {
-			case db.OutputMember:
-				txoMap[k] = v
-			default:
-				if data, err := msgpack.Marshal(v); err != nil {
-					log.Panicln(txo.Outpoint.String(), err)
-				} else {
-					txoMap[k] = data
-				}
-			}
-		}
-
-		if err = pipe.HMSet(ctx, db.TxoKey(txo.Outpoint), txoMap).Err(); err != nil {
-			log.Println(txo.Outpoint.String(), err)
-			return err
-		}
 	}
 	return nil
 }
+
+func marshal(txo *types.Txo, v interface{}) []byte {
+	if data, err := msgpack.Marshal(v); err != nil {
+		log.Panicln(txo.Outpoint.String(), err)
+		return nil
+	} else {
+		return data
+	}
+}
@@ -0,0 +1,543 @@
+package txostore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/GorillaPool/go-junglebus/models"
+	"github.com/bitcoin-sv/go-sdk/script"
+	"github.com/bitcoin-sv/go-sdk/transaction"
+	"github.com/bitcoin-sv/go-sdk/transaction/template/p2pkh"
+	"github.com/bitcoin-sv/go-sdk/util"
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/mod/ord"
+	modp2pkh "github.com/shruggr/casemod-indexer/mod/p2pkh"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+const testAddress = "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"
+
+// testHeaders is a HeaderSource holding the merkle root of each block height.
+type testHeaders map[uint32]string
+
+func (h testHeaders) Header(ctx context.Context, height uint32) (*models.BlockHeader, error) {
+	if root, ok := h[height]; ok {
+		return &models.BlockHeader{Height: height, MerkleRoot: root, Hash: "00"}, nil
+	}
+	return nil, nil
+}
+
+func (h testHeaders) IsValidRootForHeight(root []byte, height uint32) bool {
+	return h[height] == hex.EncodeToString(util.ReverseBytes(root))
+}
+
+type testChain struct {
+	t       *testing.T
+	store   *Store
+	source  *db.MemorySource
+	headers testHeaders
+}
+
+func newTestChain(t *testing.T, indexers ...types.Indexer) *testChain {
+	if len(indexers) == 0 {
+		indexers = []types.Indexer{&modp2pkh.P2pkhIndexer{}}
+	}
+	c := &testChain{
+		t:       t,
+		source:  db.NewMemorySource(),
+		headers: make(testHeaders),
+	}
+	c.store = &Store{
+		DB:       storage.NewMemoryStorage(),
+		Source:   c.source,
+		Headers:  c.headers,
+		Indexers: indexers,
+	}
+	return c
+}
+
+func lockScript(t *testing.T) *script.Script {
+	address, err := script.NewAddressFromString(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := p2pkh.Lock(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// coinbase builds a transaction minting sats to testAddress.
+func (c *testChain) coinbase(sats uint64) *transaction.Transaction {
+	tx := transaction.NewTransaction()
+	tx.AddInput(&transaction.TransactionInput{
+		SourceTXID:       make([]byte, 32),
+		SourceTxOutIndex: 0xffffffff,
+		UnlockingScript:  &script.Script{},
+		SequenceNumber:   0xffffffff,
+	})
+	tx.AddOutput(&transaction.TransactionOutput{Satoshis: sats, LockingScript: lockScript(c.t)})
+	c.source.AddTx(tx)
+	return tx
+}
+
+// spend builds a transaction spending the given outputs of parent to
+// testAddress, one output per amount in sats.
+func (c *testChain) spend(parent *transaction.Transaction, vouts []uint32, sats ...uint64) *transaction.Transaction {
+	tx := transaction.NewTransaction()
+	for _, vout := range vouts {
+		tx.AddInput(&transaction.TransactionInput{
+			SourceTXID:       parent.TxIDBytes(),
+			SourceTxOutIndex: vout,
+			UnlockingScript:  &script.Script{},
+			SequenceNumber:   0xffffffff,
+		})
+	}
+	for _, s := range sats {
+		tx.AddOutput(&transaction.TransactionOutput{Satoshis: s, LockingScript: lockScript(c.t)})
+	}
+	c.source.AddTx(tx)
+	return tx
+}
+
+// mine gives tx a proof of being the only transaction in a block at height,
+// and saves the header of that block.
+func (c *testChain) mine(tx *transaction.Transaction, height uint32) {
+	c.mineBlock(height, tx)
+}
+
+// mineBlock gives one or two transactions proofs of being the whole block at
+// height, in order, and saves the header of that block.
+func (c *testChain) mineBlock(height uint32, txs ...*transaction.Transaction) {
+	isTxid := true
+	duplicate := true
+	leaves := make([]*transaction.PathElement, 0, 2)
+	for i, tx := range txs {
+		leaves = append(leaves, &transaction.PathElement{Offset: uint64(i), Hash: util.ReverseBytes(tx.TxIDBytes()), Txid: &isTxid})
+	}
+	if len(leaves) == 1 {
+		leaves = append(leaves, &transaction.PathElement{Offset: 1, Duplicate: &duplicate})
+	}
+	for _, tx := range txs {
+		tx.MerklePath = &transaction.MerklePath{
+			BlockHeight: height,
+			Path:        [][]*transaction.PathElement{leaves},
+		}
+		txid := tx.TxID()
+		root, err := tx.MerklePath.ComputeRoot(&txid)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		c.headers[height] = root
+		c.source.AddProof(txid, tx.MerklePath)
+	}
+}
+
+func (c *testChain) ingest(tx *transaction.Transaction) *types.IndexContext {
+	idxCtx, err := c.store.Ingest(context.Background(), tx)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return idxCtx
+}
+
+func (c *testChain) txo(tx *transaction.Transaction, vout uint32) *types.Txo {
+	txo, err := c.store.LoadTxo(context.Background(), &types.Outpoint{
+		Txid: tx.TxIDBytes(),
+		Vout: vout,
+	}, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return txo
+}
+
+// status returns the score of tx in the txs set, or 0 if it is not indexed.
+func (c *testChain) status(tx *transaction.Transaction) float64 {
+	score, _, err := c.store.txoDb().Score(context.Background(), db.TxStatusKey, tx.TxID())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return score
+}
+
+// ownerScore returns the score of the output in the owner set of
+// testAddress, or 0 if it is not a member.
+func (c *testChain) ownerScore(tx *transaction.Transaction, vout uint32) float64 {
+	owner, err := types.NewPKHashFromAddress(testAddress)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	outpoint := &types.Outpoint{Txid: tx.TxIDBytes(), Vout: vout}
+	score, _, err := c.store.txoDb().Score(context.Background(), db.OwnerKey(owner), outpoint.String())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return score
+}
+
+func TestIngestRevert(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		mined bool
+	}{
+		{"mempool", false},
+		{"mined", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestChain(t)
+			parent := c.coinbase(1000)
+			c.mine(parent, 100)
+			c.ingest(parent)
+			child := c.spend(parent, []uint32{0}, 600, 400)
+			if tt.mined {
+				c.mine(child, 101)
+			}
+			c.ingest(child)
+
+			if txo := c.txo(child, 1); txo == nil || txo.Output.Satoshis != 400 || txo.Owner.Address() != testAddress {
+				t.Fatalf("child txo %+v", txo)
+			} else if mined := types.ParseBlockScore(types.BlockScore(txo.Block)) != nil; mined != tt.mined {
+				t.Fatalf("child mined %v, want %v", mined, tt.mined)
+			}
+			if txo := c.txo(parent, 0); txo.Spend == nil || hex.EncodeToString(txo.Spend.Txid) != child.TxID() {
+				t.Fatalf("parent spend %+v", txo.Spend)
+			} else if score := c.ownerScore(parent, 0); score >= 0 {
+				t.Fatalf("spent parent owner score %f", score)
+			}
+
+			if err := c.store.Revert(ctx, child.TxID()); err != nil {
+				t.Fatal(err)
+			}
+			for vout := range child.Outputs {
+				if txo := c.txo(child, uint32(vout)); txo != nil {
+					t.Fatalf("reverted txo %d still indexed", vout)
+				} else if score := c.ownerScore(child, uint32(vout)); score != 0 {
+					t.Fatalf("reverted txo %d owner score %f", vout, score)
+				}
+			}
+			if txo := c.txo(parent, 0); txo.Spend != nil {
+				t.Fatalf("parent still spent by %x", txo.Spend.Txid)
+			} else if score := c.ownerScore(parent, 0); score != 100 {
+				t.Fatalf("parent owner score %f, want 100", score)
+			} else if c.status(child) != 0 {
+				t.Fatal("reverted tx still in txs")
+			} else if entries, err := LoadJournal(ctx, c.store.txoDb(), child.TxID()); err != nil || entries != nil {
+				t.Fatalf("journal %v %v", entries, err)
+			}
+		})
+	}
+}
+
+func TestIngestTwiceRevertsToOriginal(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	parent := c.coinbase(1000)
+	c.mine(parent, 100)
+	c.ingest(parent)
+	child := c.spend(parent, []uint32{0}, 1000)
+	c.ingest(child)
+	c.mine(child, 101)
+	c.ingest(child)
+	if score := c.status(child); score != 101 {
+		t.Fatalf("child score %f, want 101", score)
+	}
+
+	if err := c.store.Revert(ctx, child.TxID()); err != nil {
+		t.Fatal(err)
+	} else if txo := c.txo(child, 0); txo != nil {
+		t.Fatal("txo still indexed")
+	} else if txo := c.txo(parent, 0); txo.Spend != nil {
+		t.Fatal("parent still spent")
+	}
+}
+
+func TestReorg(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+
+	base := c.coinbase(1000)
+	c.mine(base, 100)
+	c.ingest(base)
+	orphan := c.spend(base, []uint32{0}, 1000)
+	c.mine(orphan, 101)
+	c.ingest(orphan)
+	descendant := c.spend(orphan, []uint32{0}, 900)
+	c.ingest(descendant)
+	if err := c.store.DB.Write(ctx, func(w storage.Writer) error {
+		for height := uint32(100); height <= 101; height++ {
+			w.SaveFields(db.BlockKey, map[string][]byte{db.BlockHeightKey(height): []byte("{}")})
+			w.AddMember(db.BlockIdKey, fmt.Sprint(height), float64(height))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	reverted, err := c.store.Reorg(ctx, 101)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(reverted) != 2 || reverted[0] != orphan.TxID() || reverted[1] != descendant.TxID() {
+		t.Fatalf("reverted %v", reverted)
+	}
+	for _, tx := range []*transaction.Transaction{orphan, descendant} {
+		if c.status(tx) != 0 {
+			t.Fatalf("%s still in txs", tx.TxID())
+		} else if c.txo(tx, 0) != nil {
+			t.Fatalf("%s txo still indexed", tx.TxID())
+		}
+	}
+	if txo := c.txo(base, 0); txo.Spend != nil {
+		t.Fatal("base still spent")
+	} else if blocks, err := c.store.DB.LoadFields(ctx, db.BlockKey, db.BlockHeightKey(100), db.BlockHeightKey(101)); err != nil {
+		t.Fatal(err)
+	} else if len(blocks[db.BlockHeightKey(100)]) == 0 || len(blocks[db.BlockHeightKey(101)]) > 0 {
+		t.Fatalf("blocks %v", blocks)
+	}
+}
+
+func TestTip(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	if tip, err := c.store.Tip(ctx); err != nil || tip != 0 {
+		t.Fatalf("tip %d %v", tip, err)
+	}
+	if err := c.store.DB.Write(ctx, func(w storage.Writer) error {
+		for _, height := range []uint32{100, 102, 101} {
+			w.AddMember(db.BlockIdKey, fmt.Sprint(height), float64(height))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if tip, err := c.store.Tip(ctx); err != nil || tip != 102 {
+		t.Fatalf("tip %d %v", tip, err)
+	}
+}
+
+func TestRollbackUnindexedInput(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t, &ord.OriginIndexer{})
+	parent := c.coinbase(1000)
+	c.mine(parent, 100)
+	c.ingest(parent)
+	child := c.spend(parent, []uint32{0}, 900)
+	c.ingest(child)
+
+	outpoint := &types.Outpoint{Txid: parent.TxIDBytes(), Vout: 0}
+	spent := func() bool {
+		spends, err := c.store.loadSpends(ctx, []*types.Txo{{Outpoint: outpoint}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return spends[0] != nil
+	}
+	if c.txo(parent, 0) != nil {
+		t.Fatal("parent output indexed")
+	} else if !spent() {
+		t.Fatal("spend not recorded")
+	} else if err := c.store.Rollback(ctx, child.TxID()); err != nil {
+		t.Fatal(err)
+	} else if spent() {
+		t.Fatal("spend of unindexed output not rolled back")
+	} else if c.status(child) != 0 {
+		t.Fatal("child still in txs")
+	}
+}
+
+// failingSink fails every ingest after the first ok.
+type failingSink struct {
+	ok       int
+	reverted []string
+}
+
+func (f *failingSink) Ingest(ctx context.Context, idxCtx *types.IndexContext) error {
+	if f.ok--; f.ok < 0 {
+		return errors.New("sink failed")
+	}
+	return nil
+}
+
+func (f *failingSink) Revert(ctx context.Context, txid string) error {
+	f.reverted = append(f.reverted, txid)
+	return nil
+}
+
+func TestSinkFailure(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		reingest bool
+	}{
+		{"new tx", false},
+		{"promotion", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := newTestChain(t)
+			parent := c.coinbase(1000)
+			c.mine(parent, 100)
+			c.ingest(parent)
+			child := c.spend(parent, []uint32{0}, 1000)
+			mirrored := &failingSink{ok: 1000}
+			sink := &failingSink{ok: 0}
+			if tt.reingest {
+				c.ingest(child)
+				c.mine(child, 101)
+			}
+			c.store.Sinks = []Sink{mirrored, sink}
+
+			if _, err := c.store.Ingest(ctx, child); err == nil {
+				t.Fatal("ingest succeeded")
+			}
+			if txo := c.txo(child, 0); (txo != nil) != tt.reingest {
+				t.Fatalf("txo indexed %v", txo != nil)
+			} else if tt.reingest && (c.status(child) <= MEMPOOL_SCORE || c.ownerScore(child, 0) <= MEMPOOL_SCORE) {
+				t.Fatalf("promotion not undone: %f", c.status(child))
+			} else if spent := c.txo(parent, 0).Spend != nil; spent != tt.reingest {
+				t.Fatalf("parent spent %v", spent)
+			} else if reverted := len(mirrored.reverted) > 0; reverted == tt.reingest {
+				t.Fatalf("mirroring sink reverted %v", mirrored.reverted)
+			}
+
+			sink.ok = 1
+			c.ingest(child)
+			if c.txo(child, 0) == nil {
+				t.Fatal("retry not indexed")
+			}
+		})
+	}
+}
+
+// downStorage fails every write once down is set.
+type downStorage struct {
+	storage.Storage
+	down bool
+}
+
+func (d *downStorage) Write(ctx context.Context, fn func(storage.Writer) error) error {
+	if d.down {
+		return errors.New("storage down")
+	}
+	return d.Storage.Write(ctx, fn)
+}
+
+// downingSink takes st down and fails.
+type downingSink struct {
+	st *downStorage
+}
+
+func (d *downingSink) Ingest(ctx context.Context, idxCtx *types.IndexContext) error {
+	d.st.down = true
+	return errors.New("sink failed")
+}
+
+func (d *downingSink) Revert(ctx context.Context, txid string) error {
+	return nil
+}
+
+func TestUndoFailure(t *testing.T) {
+	c := newTestChain(t)
+	parent := c.coinbase(1000)
+	c.mine(parent, 100)
+	c.ingest(parent)
+	st := &downStorage{Storage: c.store.DB}
+	c.store.DB = st
+	c.store.Sinks = []Sink{&downingSink{st: st}}
+
+	child := c.spend(parent, []uint32{0}, 1000)
+	if _, err := c.store.Ingest(context.Background(), child); err == nil {
+		t.Fatal("ingest succeeded")
+	} else if !strings.Contains(err.Error(), "sink failed") || !strings.Contains(err.Error(), "storage down") {
+		t.Fatalf("error %v", err)
+	}
+}
+
+func TestSearchTxosWindows(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	spentMined := c.coinbase(1000)
+	c.mine(spentMined, 100)
+	c.ingest(spentMined)
+	unspentMined := c.spend(spentMined, []uint32{0}, 900)
+	c.mine(unspentMined, 101)
+	c.ingest(unspentMined)
+	spentMempool := c.coinbase(500)
+	c.mine(spentMempool, 102)
+	c.ingest(spentMempool)
+	unspentMempool := c.spend(spentMempool, []uint32{0}, 400)
+	c.ingest(unspentMempool)
+	op := func(tx *transaction.Transaction) string {
+		return (&types.Outpoint{Txid: tx.TxIDBytes(), Vout: 0}).String()
+	}
+
+	e := modp2pkh.AddressEvent(testAddress)
+	for _, tt := range []struct {
+		name   string
+		params SearchTxoParams
+		want   []*transaction.Transaction
+	}{
+		{"unspent", SearchTxoParams{}, []*transaction.Transaction{unspentMined, unspentMempool}},
+		{"unspent desc", SearchTxoParams{Desc: true}, []*transaction.Transaction{unspentMempool, unspentMined}},
+		{"spent", SearchTxoParams{Status: StatusSpent}, []*transaction.Transaction{spentMined, spentMempool}},
+		{"spent desc", SearchTxoParams{Status: StatusSpent, Desc: true}, []*transaction.Transaction{spentMempool, spentMined}},
+		{"unspent from height", SearchTxoParams{FromHeight: 102}, []*transaction.Transaction{unspentMempool}},
+		{"unspent to height", SearchTxoParams{ToHeight: 101}, []*transaction.Transaction{unspentMined}},
+		{"spent from height", SearchTxoParams{Status: StatusSpent, FromHeight: 102}, []*transaction.Transaction{spentMempool}},
+		{"spent to height", SearchTxoParams{Status: StatusSpent, ToHeight: 101}, []*transaction.Transaction{spentMined}},
+		{"mempool", SearchTxoParams{Mempool: true}, []*transaction.Transaction{unspentMempool}},
+		{"spent in mempool", SearchTxoParams{Status: StatusSpent, Mempool: true}, []*transaction.Transaction{spentMempool}},
+		{"all by height of creation or spend", SearchTxoParams{Status: StatusAll, ToHeight: 100}, []*transaction.Transaction{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			params := tt.params
+			params.Tag, params.Id, params.Value = modp2pkh.TAG, e.Label, e.Value
+			txos, err := c.store.SearchTxos(ctx, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(txos))
+			for _, txo := range txos {
+				got = append(got, txo.Outpoint.String())
+			}
+			want := make([]string, 0, len(tt.want))
+			for _, tx := range tt.want {
+				want = append(want, op(tx))
+			}
+			if !slices.Equal(got, want) {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	if txos, err := c.store.SearchTxos(ctx, SearchTxoParams{Tag: modp2pkh.TAG, Id: e.Label, Value: e.Value, Status: StatusAll}); err != nil {
+		t.Fatal(err)
+	} else if len(txos) != 4 {
+		t.Fatalf("%d txos in all", len(txos))
+	} else if _, err := c.store.SearchTxos(ctx, SearchTxoParams{Tag: modp2pkh.TAG, Status: "bogus"}); !errors.Is(err, ErrInvalidStatus) {
+		t.Fatalf("bogus status %v", err)
+	}
+}
+
+func TestIngestInvalidProof(t *testing.T) {
+	ctx := context.Background()
+	c := newTestChain(t)
+	tx := c.coinbase(1000)
+	c.mine(tx, 100)
+	c.headers[100] = "00"
+
+	if _, err := c.store.Ingest(ctx, tx); !errors.Is(err, db.ErrInvalidProof) {
+		t.Fatalf("ingest err %v", err)
+	} else if c.status(tx) != 0 {
+		t.Fatal("tx indexed with a mismatched proof")
+	} else if _, err := c.source.LoadProof(ctx, tx.TxID()); !errors.Is(err, db.ErrInvalidProof) {
+		t.Fatalf("quarantined proof served: %v", err)
+	}
+}
@@ -13,11 +13,21 @@ type IndexContext struct {
 	Block  *Block
 	Spends []*Txo
 	Txos   []*Txo
+	// Reingest is set once the txos are stored if the transaction had been
+	// ingested before, as when an unmined tx is promoted to its block.
+	Reingest bool
+	// Fees holds, for a coinbase, the fee data each FeeIndexer recorded for
+	// the other transactions of its block, by tag and in block order.
+	Fees map[string][]*IndexData
 }
 
 type EventLog struct {
 	Label string
 	Value string
+	// Search marks events, such as the words of a text, which are only
+	// written to their own event key: they are not added to the owner's
+	// keys, published or delivered to webhooks.
+	Search bool `json:",omitempty" msgpack:",omitempty"`
 }
 
 type IndexData struct {
@@ -40,6 +50,29 @@ type Indexer interface {
 	UnmarshalData([]byte) (any, error)
 }
 
+// ContentOmitter is implemented by indexed objects carrying content bytes
+// which may be left out of lightweight responses.
+type ContentOmitter interface {
+	OmitContent()
+}
+
+// AncestorIndexer is implemented by indexers which carry data forward from
+// the outputs a transaction spends. A spent output it needs whose transaction
+// was never indexed is loaded and ingested before the spending transaction.
+type AncestorIndexer interface {
+	NeedsAncestor(output *transaction.TransactionOutput) bool
+}
+
+// FeeIndexer is implemented by indexers which carry data from the inputs of a
+// mined transaction through its fee into the coinbase of its block. Fee
+// returns the data for the fee of a transaction, or nil if it pays none. A
+// coinbase is parsed with the fees recorded so far for its block, and is
+// parsed again before an output missing the indexer's data is spent, by
+// which time the rest of its block has been ingested.
+type FeeIndexer interface {
+	Fee(idxCtx *IndexContext) *IndexData
+}
+
 type BaseIndexer struct{}
 
 func (b *BaseIndexer) Score(txo *Txo) float64 {
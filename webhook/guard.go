@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// allowPrivate accepts http URLs and private hosts, so tests can deliver to
+// a local server.
+var allowPrivate = false
+
+var errPrivateHost = errors.New("private host")
+
+// publicIP reports whether a webhook may be delivered to ip, which must not be
+// a loopback, private, link-local, multicast or unspecified address.
+func publicIP(ip net.IP) bool {
+	return allowPrivate || !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified())
+}
+
+// checkUrl requires an https URL whose host only resolves to public
+// addresses.
+func checkUrl(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" || (u.Scheme != "https" && !(allowPrivate && u.Scheme == "http")) {
+		return fmt.Errorf("%w: url %s", ErrInvalidWebhook, raw)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("%w: url %s: %s", ErrInvalidWebhook, raw, err)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return fmt.Errorf("%w: url %s: %s %s", ErrInvalidWebhook, raw, errPrivateHost, addr.IP)
+		}
+	}
+	return nil
+}
+
+// dialPublic refuses connections to non-public addresses. It runs once the
+// host has been resolved, so a name rebound to a private address after
+// registration, or a redirect to one, is still refused.
+func dialPublic(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	} else if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", errPrivateHost, address)
+	}
+	return nil
+}
+
+// deliveryClient posts deliveries, only ever connecting to public addresses.
+var deliveryClient = &http.Client{
+	Timeout: 30 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: dialPublic,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	EventCreate = "create"
+	EventSpend  = "spend"
+)
+
+// Sink queues a delivery for every webhook registered for an event key of
+// the txos a transaction creates or spends. Spends the txo store did not
+// record, having kept a conflicting one, are not delivered. Nothing is
+// queued when a transaction is ingested again, so a promotion is not
+// delivered twice. Queued deliveries are not withdrawn by Revert, so it
+// should be the last sink of a Store, reached only once every other sink has
+// succeeded.
+type Sink struct {
+	DB storage.Storage
+}
+
+func NewSink(st storage.Storage) *Sink {
+	return &Sink{DB: st}
+}
+
+func (s *Sink) Ingest(ctx context.Context, idxCtx *types.IndexContext) error {
+	if idxCtx.Reingest {
+		return nil
+	}
+	txid := hex.EncodeToString(idxCtx.Txid)
+	spends, err := s.persistedSpends(ctx, idxCtx)
+	if err != nil {
+		return err
+	}
+	queued := make(map[string]struct{})
+	for _, batch := range []struct {
+		event string
+		txos  []*types.Txo
+	}{
+		{EventSpend, spends},
+		{EventCreate, idxCtx.Txos},
+	} {
+		event := batch.event
+		for _, txo := range batch.txos {
+			outpoint := txo.Outpoint.String()
+			for _, channel := range txoChannels(txo) {
+				hooks, err := s.DB.RangeByScore(ctx, db.WebhookChannelKey(channel), storage.AllScores())
+				if err != nil {
+					return err
+				}
+				for _, hook := range hooks {
+					id := hook.Member + ":" + event + ":" + outpoint
+					if _, ok := queued[id]; ok {
+						continue
+					}
+					queued[id] = struct{}{}
+					if err := s.DB.AppendStream(ctx, db.WebhookQueueKey, "*", map[string]string{
+						"hook":     hook.Member,
+						"channel":  channel,
+						"event":    event,
+						"outpoint": outpoint,
+						"txid":     txid,
+					}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// persistedSpends returns the inputs recorded as spent by the transaction,
+// leaving out those whose conflicting spend was kept.
+func (s *Sink) persistedSpends(ctx context.Context, idxCtx *types.IndexContext) ([]*types.Txo, error) {
+	lookups := make([]*storage.Lookup, 0, len(idxCtx.Spends))
+	for _, txo := range idxCtx.Spends {
+		lookups = append(lookups, &storage.Lookup{
+			Key:   db.TxoKey(txo.Outpoint),
+			Field: db.SpendMember,
+		})
+	}
+	if err := s.DB.Lookup(ctx, lookups); err != nil {
+		return nil, err
+	}
+	spends := make([]*types.Txo, 0, len(idxCtx.Spends))
+	for i, l := range lookups {
+		spend := &types.Spend{}
+		if !l.Exists || len(l.Value) == 0 {
+			continue
+		} else if err := msgpack.Unmarshal(l.Value, spend); err != nil {
+			return nil, err
+		} else if bytes.Equal(spend.Txid, idxCtx.Txid) {
+			spends = append(spends, idxCtx.Spends[i])
+		}
+	}
+	return spends, nil
+}
+
+// Revert leaves deliveries already queued for txid in place.
+func (s *Sink) Revert(ctx context.Context, txid string) error {
+	return nil
+}
+
+func txoChannels(txo *types.Txo) []string {
+	channels := make([]string, 0)
+	for tag, data := range txo.Data {
+		for _, e := range data.Events {
+			if !e.Search {
+				channels = append(channels, db.EventKey(tag, e))
+			}
+		}
+	}
+	if txo.Owner != nil {
+		channels = append(channels, db.OwnerKey(txo.Owner))
+	}
+	return channels
+}
@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+const hookField = "hook"
+const statusField = "status"
+
+// Webhook is a URL notified whenever a txo matching any of its filters is
+// created or spent. Events are tag:id:value event keys and Owners are
+// addresses. Deliveries are signed with Secret.
+type Webhook struct {
+	Id     string   `json:"id"`
+	Url    string   `json:"url"`
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events,omitempty"`
+	Owners []string `json:"owners,omitempty"`
+	Status *Status  `json:"status,omitempty"`
+}
+
+type Status struct {
+	Delivered   uint64 `json:"delivered"`
+	Failed      uint64 `json:"failed"`
+	LastStatus  int    `json:"lastStatus,omitempty"`
+	LastError   string `json:"lastError,omitempty"`
+	LastAttempt int64  `json:"lastAttempt,omitempty"`
+}
+
+// Channels returns the event keys the webhook is notified for.
+func (w *Webhook) Channels() ([]string, error) {
+	channels := make([]string, 0, len(w.Events)+len(w.Owners))
+	for _, event := range w.Events {
+		parts := strings.SplitN(event, ":", 3)
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("%w: event %s", ErrInvalidWebhook, event)
+		}
+		channels = append(channels, db.EventKey(parts[0], &types.EventLog{
+			Label: parts[1],
+			Value: parts[2],
+		}))
+	}
+	for _, address := range w.Owners {
+		if owner, err := types.NewPKHashFromAddress(address); err != nil {
+			return nil, fmt.Errorf("%w: owner %s", ErrInvalidWebhook, address)
+		} else {
+			channels = append(channels, db.OwnerKey(owner))
+		}
+	}
+	return channels, nil
+}
+
+// Register validates and saves w, assigning its Id and, if not set, a
+// random Secret. The URL must be https and its host must resolve only to
+// public addresses.
+func Register(ctx context.Context, st storage.Storage, w *Webhook) error {
+	if err := checkUrl(ctx, w.Url); err != nil {
+		return err
+	}
+	channels, err := w.Channels()
+	if err != nil {
+		return err
+	} else if len(channels) == 0 {
+		return fmt.Errorf("%w: no events or owners", ErrInvalidWebhook)
+	}
+	w.Id = randomHex(16)
+	if w.Secret == "" {
+		w.Secret = randomHex(32)
+	}
+	w.Status = nil
+	hook, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	status, err := json.Marshal(&Status{})
+	if err != nil {
+		return err
+	}
+	return st.Write(ctx, func(wr storage.Writer) error {
+		wr.SaveFields(db.WebhookKey(w.Id), map[string][]byte{
+			hookField:   hook,
+			statusField: status,
+		})
+		for _, channel := range channels {
+			wr.AddMember(db.WebhookChannelKey(channel), w.Id, float64(time.Now().Unix()))
+		}
+		return nil
+	})
+}
+
+// Load returns the webhook with its delivery status, or nil if id is not
+// registered.
+func Load(ctx context.Context, st storage.Storage, id string) (*Webhook, error) {
+	fields, err := st.LoadFields(ctx, db.WebhookKey(id), hookField, statusField)
+	if err != nil {
+		return nil, err
+	} else if len(fields[hookField]) == 0 {
+		return nil, nil
+	}
+	w := &Webhook{}
+	if err := json.Unmarshal(fields[hookField], w); err != nil {
+		return nil, err
+	}
+	w.Status = &Status{}
+	if len(fields[statusField]) > 0 {
+		if err := json.Unmarshal(fields[statusField], w.Status); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Delete unregisters the webhook. Queued deliveries for it are dropped by
+// the Worker.
+func Delete(ctx context.Context, st storage.Storage, id string) error {
+	w, err := Load(ctx, st, id)
+	if err != nil || w == nil {
+		return err
+	}
+	channels, err := w.Channels()
+	if err != nil {
+		return err
+	}
+	return st.Write(ctx, func(wr storage.Writer) error {
+		for _, channel := range channels {
+			wr.RemoveMember(db.WebhookChannelKey(channel), id)
+		}
+		wr.Delete(db.WebhookKey(id))
+		return nil
+	})
+}
+
+func saveStatus(ctx context.Context, st storage.Storage, id string, status *Status) error {
+	if data, err := json.Marshal(status); err != nil {
+		return err
+	} else {
+		return st.Write(ctx, func(wr storage.Writer) error {
+			wr.SaveFields(db.WebhookKey(id), map[string][]byte{
+				statusField: data,
+			})
+			return nil
+		})
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,257 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/txostore"
+	"github.com/shruggr/casemod-indexer/types"
+)
+
+const MAX_ATTEMPTS = 8
+const RETRY_BASE = 10 * time.Second
+const RETRY_MAX = time.Hour
+const POLL_INTERVAL = time.Second
+const BATCH_SIZE = 100
+
+// Delivery is a queued notification. It is kept in the retry set, with
+// Attempt counting the failed attempts, until it succeeds or runs out of
+// attempts.
+type Delivery struct {
+	Id       string `json:"id"`
+	Hook     string `json:"hook"`
+	Channel  string `json:"channel"`
+	Event    string `json:"event"`
+	Outpoint string `json:"outpoint"`
+	Txid     string `json:"txid"`
+	Attempt  int    `json:"attempt"`
+}
+
+// Payload is the body posted to a webhook. Its HMAC-SHA256, keyed with the
+// webhook secret, is sent hex encoded in the X-Webhook-Signature header.
+type Payload struct {
+	Id        string     `json:"id"`
+	Hook      string     `json:"hook"`
+	Channel   string     `json:"channel"`
+	Event     string     `json:"event"`
+	Outpoint  string     `json:"outpoint"`
+	Txid      string     `json:"txid"`
+	Timestamp int64      `json:"timestamp"`
+	Txo       *types.Txo `json:"txo"`
+}
+
+// Worker posts the deliveries queued by Sink, retrying failures with
+// exponential backoff. Only one Worker should run against a queue.
+type Worker struct {
+	DB    storage.Storage
+	Store *txostore.Store
+	// Client posts deliveries. The default only connects to public addresses.
+	Client      *http.Client
+	MaxAttempts int
+}
+
+func (w *Worker) Run(ctx context.Context) error {
+	for {
+		if n, err := w.Process(ctx); err != nil {
+			return err
+		} else if n == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(POLL_INTERVAL):
+			}
+		}
+	}
+}
+
+// Process attempts the retries that are due and then the next batch of
+// queued deliveries, returning how many were attempted. Queued deliveries
+// before the last one attempted are trimmed from the stream.
+func (w *Worker) Process(ctx context.Context) (int, error) {
+	count := 0
+	retries, err := w.DB.RangeByScore(ctx, db.WebhookRetryKey, &storage.ScoreRange{
+		Min:   math.Inf(-1),
+		Max:   float64(time.Now().Unix()),
+		Count: BATCH_SIZE,
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, m := range retries {
+		d := &Delivery{}
+		if err := json.Unmarshal([]byte(m.Member), d); err != nil {
+			log.Println("Webhook retry", err)
+		} else if err := w.deliver(ctx, d); err != nil {
+			return count, err
+		}
+		if err := w.DB.Write(ctx, func(wr storage.Writer) error {
+			wr.RemoveMember(db.WebhookRetryKey, m.Member)
+			return nil
+		}); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	progress, err := w.DB.Get(ctx, db.WebhookProgressKey)
+	if err != nil {
+		return count, err
+	}
+	start := "-"
+	if len(progress) > 0 {
+		start = "(" + string(progress)
+	}
+	entries, err := w.DB.ReadStream(ctx, db.WebhookQueueKey, &storage.StreamRange{
+		Start: start,
+		Stop:  "+",
+		Count: BATCH_SIZE,
+	})
+	if err != nil {
+		return count, err
+	}
+	for _, entry := range entries {
+		if err := w.deliver(ctx, &Delivery{
+			Id:       entry.Id,
+			Hook:     entry.Values["hook"],
+			Channel:  entry.Values["channel"],
+			Event:    entry.Values["event"],
+			Outpoint: entry.Values["outpoint"],
+			Txid:     entry.Values["txid"],
+		}); err != nil {
+			return count, err
+		}
+		if err := w.DB.Write(ctx, func(wr storage.Writer) error {
+			wr.Set(db.WebhookProgressKey, []byte(entry.Id))
+			return nil
+		}); err != nil {
+			return count, err
+		}
+		count++
+	}
+	if len(entries) > 0 {
+		if err := w.DB.TrimStream(ctx, db.WebhookQueueKey, entries[len(entries)-1].Id); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// deliver posts d and records the outcome. A failed post is scheduled for
+// retry; only storage errors are returned.
+func (w *Worker) deliver(ctx context.Context, d *Delivery) error {
+	hook, err := Load(ctx, w.DB, d.Hook)
+	if err != nil {
+		return err
+	} else if hook == nil {
+		return nil
+	}
+	payload := &Payload{
+		Id:        d.Id,
+		Hook:      d.Hook,
+		Channel:   d.Channel,
+		Event:     d.Event,
+		Outpoint:  d.Outpoint,
+		Txid:      d.Txid,
+		Timestamp: time.Now().Unix(),
+	}
+	if outpoint, err := types.NewOutpointFromString(d.Outpoint); err != nil {
+		log.Println("Webhook", d.Id, err)
+		return nil
+	} else if payload.Txo, err = w.Store.LoadTxo(ctx, outpoint, nil); err != nil {
+		return err
+	}
+
+	status := hook.Status
+	status.LastAttempt = payload.Timestamp
+	status.LastStatus, err = w.post(ctx, hook, d, payload)
+	if err == nil {
+		status.Delivered++
+		status.LastError = ""
+		return saveStatus(ctx, w.DB, hook.Id, status)
+	}
+	status.LastError = err.Error()
+	d.Attempt++
+	if d.Attempt >= w.maxAttempts() {
+		log.Println("Webhook", hook.Id, "dropping", d.Id, err)
+		status.Failed++
+		return saveStatus(ctx, w.DB, hook.Id, status)
+	}
+	retry, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	next := time.Now().Add(backoff(d.Attempt)).Unix()
+	if err := w.DB.Write(ctx, func(wr storage.Writer) error {
+		wr.AddMember(db.WebhookRetryKey, string(retry), float64(next))
+		return nil
+	}); err != nil {
+		return err
+	}
+	return saveStatus(ctx, w.DB, hook.Id, status)
+}
+
+func (w *Worker) post(ctx context.Context, hook *Webhook, d *Delivery, payload *Payload) (int, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", hook.Id)
+	req.Header.Set("X-Webhook-Delivery", d.Id)
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(d.Attempt+1))
+	req.Header.Set("X-Webhook-Signature", Sign(hook.Secret, body))
+	client := w.Client
+	if client == nil {
+		client = deliveryClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (w *Worker) maxAttempts() int {
+	if w.MaxAttempts > 0 {
+		return w.MaxAttempts
+	}
+	return MAX_ATTEMPTS
+}
+
+// Sign returns the hex HMAC-SHA256 of body keyed with secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff doubles the delay after each failed attempt, up to RETRY_MAX.
+func backoff(attempt int) time.Duration {
+	delay := RETRY_BASE
+	for i := 1; i < attempt && delay < RETRY_MAX; i++ {
+		delay *= 2
+	}
+	return min(delay, RETRY_MAX)
+}
@@ -0,0 +1,224 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shruggr/casemod-indexer/db"
+	"github.com/shruggr/casemod-indexer/storage"
+	"github.com/shruggr/casemod-indexer/txostore"
+	"github.com/shruggr/casemod-indexer/types"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const testAddress = "1BgGZ9tcN4rm9KBzDn7KprQz87SZ26SAMH"
+
+func TestSign(t *testing.T) {
+	// The widely published HMAC-SHA256 example
+	if sig := Sign("key", []byte("The quick brown fox jumps over the lazy dog")); sig != "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8" {
+		t.Fatalf("signature %s", sig)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for _, tt := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, RETRY_BASE},
+		{1, RETRY_BASE},
+		{2, 2 * RETRY_BASE},
+		{4, 8 * RETRY_BASE},
+		{9, 256 * RETRY_BASE},
+		{10, RETRY_MAX},
+		{100, RETRY_MAX},
+	} {
+		if got := backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterUrl(t *testing.T) {
+	for _, tt := range []struct {
+		url string
+		ok  bool
+	}{
+		{"https://8.8.8.8/hook", true},
+		{"http://8.8.8.8/hook", false},
+		{"ftp://8.8.8.8/hook", false},
+		{"https://127.0.0.1/hook", false},
+		{"https://localhost/hook", false},
+		{"https://10.0.0.1/hook", false},
+		{"https://192.168.1.1/hook", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://[::1]/hook", false},
+		{"https://[fe80::1]/hook", false},
+		{"https://0.0.0.0/hook", false},
+	} {
+		hook := &Webhook{Url: tt.url, Owners: []string{testAddress}}
+		if err := Register(context.Background(), storage.NewMemoryStorage(), hook); (err == nil) != tt.ok {
+			t.Errorf("Register(%s) = %v", tt.url, err)
+		} else if !tt.ok && !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("Register(%s) = %v, want ErrInvalidWebhook", tt.url, err)
+		}
+	}
+}
+
+func TestDeliveryRefusesPrivateHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("delivered to a loopback address")
+	}))
+	defer server.Close()
+	if _, err := deliveryClient.Post(server.URL, "application/json", nil); !errors.Is(err, errPrivateHost) {
+		t.Fatalf("post %v", err)
+	}
+}
+
+func TestSinkSkipsConflictingSpends(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	allowPrivate = true
+	defer func() { allowPrivate = false }()
+	if err := Register(ctx, st, &Webhook{Url: "http://127.0.0.1/hook", Owners: []string{testAddress}}); err != nil {
+		t.Fatal(err)
+	}
+	owner, err := types.NewPKHashFromAddress(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txid := make([]byte, 32)
+	txid[0] = 1
+	other := make([]byte, 32)
+	other[0] = 2
+	spends := make([]*types.Txo, 0, 2)
+	for vout, spender := range [][]byte{txid, other} {
+		txo := &types.Txo{
+			Outpoint: &types.Outpoint{Txid: make([]byte, 32), Vout: uint32(vout)},
+			Owner:    owner,
+		}
+		spend, err := msgpack.Marshal(&types.Spend{Txid: spender})
+		if err != nil {
+			t.Fatal(err)
+		} else if err := st.Write(ctx, func(w storage.Writer) error {
+			w.SaveFields(db.TxoKey(txo.Outpoint), map[string][]byte{db.SpendMember: spend})
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		spends = append(spends, txo)
+	}
+
+	if err := NewSink(st).Ingest(ctx, &types.IndexContext{Txid: txid, Spends: spends}); err != nil {
+		t.Fatal(err)
+	} else if queued, err := st.ReadStream(ctx, db.WebhookQueueKey, &storage.StreamRange{Start: "-", Stop: "+"}); err != nil {
+		t.Fatal(err)
+	} else if len(queued) != 1 || queued[0].Values["outpoint"] != spends[0].Outpoint.String() {
+		t.Fatalf("queued %v", queued)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	allowPrivate = true
+	defer func() { allowPrivate = false }()
+	for _, tt := range []struct {
+		name      string
+		status    int
+		events    bool
+		delivered uint64
+		retries   int
+	}{
+		{"delivered", http.StatusOK, true, 2, 0},
+		{"retried", http.StatusInternalServerError, true, 0, 2},
+		{"owner only", http.StatusOK, false, 2, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := storage.NewMemoryStorage()
+			hook := &Webhook{Owners: []string{testAddress}, Secret: "secret"}
+			var payload *Payload
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if r.Header.Get("X-Webhook-Signature") != Sign(hook.Secret, body) {
+					t.Error("bad signature")
+				}
+				payload = &Payload{}
+				if err := json.Unmarshal(body, payload); err != nil {
+					t.Error(err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+			hook.Url = server.URL
+			if err := Register(ctx, st, hook); err != nil {
+				t.Fatal(err)
+			}
+
+			owner, err := types.NewPKHashFromAddress(testAddress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			txid := make([]byte, 32)
+			txos := make([]*types.Txo, 0, 2)
+			for vout := uint32(0); vout < 2; vout++ {
+				txo := &types.Txo{
+					Outpoint: &types.Outpoint{Txid: txid, Vout: vout},
+					Owner:    owner,
+				}
+				if tt.events {
+					txo.Data = map[string]*types.IndexData{
+						"p2pkh": {Events: []*types.EventLog{{Label: "address", Value: testAddress}}},
+					}
+				}
+				txos = append(txos, txo)
+			}
+			if err := NewSink(st).Ingest(ctx, &types.IndexContext{Txid: txid, Txos: txos}); err != nil {
+				t.Fatal(err)
+			}
+
+			worker := &Worker{DB: st, Store: &txostore.Store{DB: st}}
+			if n, err := worker.Process(ctx); err != nil {
+				t.Fatal(err)
+			} else if n != 2 {
+				t.Fatalf("processed %d", n)
+			} else if payload == nil || payload.Event != EventCreate || payload.Hook != hook.Id {
+				t.Fatalf("payload %+v", payload)
+			} else if queued, err := st.ReadStream(ctx, db.WebhookQueueKey, &storage.StreamRange{Start: "-", Stop: "+"}); err != nil {
+				t.Fatal(err)
+			} else if len(queued) != 1 {
+				t.Fatalf("%d deliveries left in the queue", len(queued))
+			}
+
+			if loaded, err := Load(ctx, st, hook.Id); err != nil {
+				t.Fatal(err)
+			} else if loaded.Status.Delivered != tt.delivered || loaded.Status.LastStatus != tt.status {
+				t.Fatalf("status %+v", loaded.Status)
+			}
+			retries, err := st.RangeByScore(ctx, db.WebhookRetryKey, storage.AllScores())
+			if err != nil {
+				t.Fatal(err)
+			} else if len(retries) != tt.retries {
+				t.Fatalf("%d retries", len(retries))
+			}
+			for _, m := range retries {
+				d := &Delivery{}
+				if err := json.Unmarshal([]byte(m.Member), d); err != nil {
+					t.Fatal(err)
+				} else if d.Attempt != 1 {
+					t.Fatalf("attempt %d", d.Attempt)
+				} else if due := time.Unix(int64(m.Score), 0); time.Until(due) < RETRY_BASE-time.Second {
+					t.Fatalf("retry due %s", due)
+				}
+			}
+			if n, err := worker.Process(ctx); err != nil || n != 0 {
+				t.Fatalf("reprocessed %d %v", n, err)
+			}
+		})
+	}
+}